@@ -0,0 +1,140 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"strconv"
+
+	"github.com/kevinjos/openbci-driver"
+)
+
+//AuxFormat tells how the six aux bytes of a frame are laid out.
+//The firmware signals it with the stop byte, 0xC0 through 0xC6.
+type AuxFormat uint8
+
+const (
+	AuxAccel          AuxFormat = iota //0xC0 accelerometer X, Y, Z
+	AuxRaw                             //0xC1 raw aux, e.g. digital or analog pins
+	AuxUser                            //0xC2 user defined
+	AuxTimeSetAccel                    //0xC3 one accel axis and a synced timestamp
+	AuxTimeUnsetAccel                  //0xC4 one accel axis and an unsynced timestamp
+	AuxTimeSetRaw                      //0xC5 raw aux and a synced timestamp
+	AuxTimeUnsetRaw                    //0xC6 raw aux and an unsynced timestamp
+)
+
+//Sample numbers ending in these digits carry the accelerometer axis
+//in time stamped frames, the remaining frames carry zeros
+const (
+	accelAxisX = 7
+	accelAxisY = 8
+	accelAxisZ = 9
+)
+
+//isStopByte reports whether b terminates a frame
+func isStopByte(b byte) bool {
+	return b >= openbci.Command["footer"] && b <= openbci.Command["footer"]+byte(AuxTimeUnsetRaw)
+}
+
+func auxFormat(stop byte) AuxFormat {
+	return AuxFormat(stop - openbci.Command["footer"])
+}
+
+//HasAccel reports whether the aux bytes hold accelerometer data
+func (f AuxFormat) HasAccel() bool {
+	return f == AuxAccel || f == AuxTimeSetAccel || f == AuxTimeUnsetAccel
+}
+
+//HasTimestamp reports whether the last four aux bytes hold the board time
+func (f AuxFormat) HasTimestamp() bool {
+	return f >= AuxTimeSetAccel && f <= AuxTimeUnsetRaw
+}
+
+//TimeSet reports whether the board time has been synced with the host
+func (f AuxFormat) TimeSet() bool {
+	return f == AuxTimeSetAccel || f == AuxTimeSetRaw
+}
+
+//decodeAux fills the aux fields of packet from bytes 26 to 32 of frame.
+//Fields the format does not carry are left untouched so the two frames
+//of a daisy sample can be decoded into the same packet.
+func decodeAux(packet *Packet, frame *[33]byte) {
+	f := auxFormat(frame[32])
+	packet.footer = frame[32]
+	packet.AuxFormat = f
+	aux := frame[26:32]
+	if f.HasTimestamp() {
+		packet.BoardTime = binary.BigEndian.Uint32(frame[28:32])
+		aux = frame[26:28]
+	}
+	switch f {
+	case AuxAccel:
+		packet.AccX = convert16bitTo32bit(aux[0:2])
+		packet.AccY = convert16bitTo32bit(aux[2:4])
+		packet.AccZ = convert16bitTo32bit(aux[4:6])
+	case AuxTimeSetAccel, AuxTimeUnsetAccel:
+		switch frame[1] % 10 {
+		case accelAxisX:
+			packet.AccX = convert16bitTo32bit(aux)
+		case accelAxisY:
+			packet.AccY = convert16bitTo32bit(aux)
+		case accelAxisZ:
+			packet.AccZ = convert16bitTo32bit(aux)
+		}
+	default:
+		packet.Aux = make([]uint16, len(aux)/2)
+		for i := range packet.Aux {
+			packet.Aux[i] = binary.BigEndian.Uint16(aux[2*i:])
+		}
+	}
+}
+
+//auxValuesMax is the number of 16 bit raw aux values in a frame
+const auxValuesMax = 3
+
+//auxValues flattens the aux fields and the gap flags of a packet for
+//publishing to clients
+func (p *Packet) auxValues() map[string]float64 {
+	m := map[string]float64{
+		"AuxFormat": float64(p.AuxFormat),
+		"AccX":      float64(p.AccX),
+		"AccY":      float64(p.AccY),
+		"AccZ":      float64(p.AccZ),
+		"BoardTime": float64(p.BoardTime),
+		"Lost":      float64(p.Lost),
+		"Timestamp": float64(p.Timestamp.UnixNano()) / 1e9,
+	}
+	if p.Synthesized {
+		m["Synthesized"] = 1
+	} else {
+		m["Synthesized"] = 0
+	}
+	for i := 0; i < auxValuesMax; i++ {
+		var val float64
+		if i < len(p.Aux) {
+			val = float64(p.Aux[i])
+		}
+		m[auxName(i)] = val
+	}
+	return m
+}
+
+func auxName(i int) string {
+	return "Aux" + strconv.Itoa(i+1)
+}
@@ -0,0 +1,187 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+//maxBandRate caps how many times a second band powers are sent
+const maxBandRate = 50
+
+//Band is a frequency band from Low up to but not including High, in Hz
+type Band struct {
+	Name      string
+	Low, High float64
+}
+
+//BandConfig sets up the band power stream. Rate is how many times a
+//second the powers are sent, 0 turns the stream off. With Log set the
+//powers are also written next to recordings while saving.
+type BandConfig struct {
+	Bands []Band
+	Rate  float64
+	Log   bool
+}
+
+//defaultBandConfig covers the classic bands. The powers are taken ahead of
+//the channel filters, after the mains notch, so the default band-pass
+//does not cut gamma out of them.
+var defaultBandConfig = BandConfig{
+	Bands: []Band{
+		{"delta", 1, 4},
+		{"theta", 4, 8},
+		{"alpha", 8, 13},
+		{"beta", 13, 30},
+		{"gamma", 30, 45},
+	},
+	Rate: 1,
+}
+
+var errSavingBands = errors.New("cannot change band power logging while saving")
+
+func (c BandConfig) validate() error {
+	if c.Rate < 0 || c.Rate > maxBandRate {
+		return fmt.Errorf("rate %g out of range 0-%d", c.Rate, maxBandRate)
+	}
+	if c.Rate > 0 && len(c.Bands) == 0 {
+		return errors.New("no bands")
+	}
+	seen := make(map[string]bool)
+	for _, b := range c.Bands {
+		if b.Name == "" {
+			return errors.New("band without a name")
+		}
+		if b.Low < 0 || b.High <= b.Low {
+			return fmt.Errorf("band %s: %g-%g Hz is not a band", b.Name, b.Low, b.High)
+		}
+		//the relative powers are sent under the name with Rel appended
+		for _, key := range []string{b.Name, b.Name + "Rel"} {
+			if seen[key] {
+				return fmt.Errorf("band %s clashes with another band", b.Name)
+			}
+			seen[key] = true
+		}
+	}
+	return nil
+}
+
+//every is the number of samples at rate between two updates
+func (c BandConfig) every(rate int) int {
+	if c.Rate == 0 {
+		return 0
+	}
+	n := int(math.Round(float64(rate) / c.Rate))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+//span is the range of frequencies covered by the bands, the relative
+//powers are relative to the power over it
+func (c BandConfig) span() (low, high float64) {
+	low, high = math.Inf(1), math.Inf(-1)
+	for _, b := range c.Bands {
+		low = math.Min(low, b.Low)
+		high = math.Max(high, b.High)
+	}
+	return low, high
+}
+
+//bandPowers are the absolute powers in µV² and the relative powers of
+//every band on every channel at one time, indexed by channel then band
+type bandPowers struct {
+	time     time.Time
+	bands    []Band
+	absolute [][]float64
+	relative [][]float64
+}
+
+//computeBandPowers integrates the spectra in psd, published per channel
+//with bins df Hz apart in the given scale, over the bands in c
+func computeBandPowers(psd map[string][]float64, df float64, scale string, c BandConfig, channels int) bandPowers {
+	bp := bandPowers{
+		bands:    c.Bands,
+		absolute: make([][]float64, channels),
+		relative: make([][]float64, channels),
+	}
+	low, high := c.span()
+	for ch := range bp.absolute {
+		bp.absolute[ch] = make([]float64, len(c.Bands))
+		bp.relative[ch] = make([]float64, len(c.Bands))
+		var total float64
+		for k, val := range psd[chanName(ch)] {
+			f := float64(k) * df
+			if scale == scaleDB && val > dbFloor {
+				val = math.Pow(10, val/10)
+			} else if scale == scaleDB {
+				val = 0
+			}
+			if f >= low && f < high {
+				total += val * df
+			}
+			for j, b := range c.Bands {
+				if f >= b.Low && f < b.High {
+					bp.absolute[ch][j] += val * df
+				}
+			}
+		}
+		for j := range c.Bands {
+			if total > 0 {
+				bp.relative[ch][j] = bp.absolute[ch][j] / total
+			}
+		}
+	}
+	return bp
+}
+
+//payload lays bp out for the bands message: the absolute power of every
+//channel under the band's name and the relative power under the name
+//with Rel appended
+func (bp bandPowers) payload() map[string][]float64 {
+	m := make(map[string][]float64)
+	for j, b := range bp.bands {
+		m[b.Name] = make([]float64, len(bp.absolute))
+		m[b.Name+"Rel"] = make([]float64, len(bp.absolute))
+		for ch := range bp.absolute {
+			m[b.Name][ch] = bp.absolute[ch][j]
+			m[b.Name+"Rel"][ch] = bp.relative[ch][j]
+		}
+	}
+	return m
+}
+
+//SetBands switches the band power stream to c
+func (mc *MindControl) SetBands(c BandConfig) (BandConfig, error) {
+	err := c.validate()
+	if err != nil {
+		return mc.bands, err
+	}
+	if mc.saving && (c.Log || mc.bands.Log) {
+		return mc.bands, errSavingBands
+	}
+	c.Bands = append([]Band(nil), c.Bands...)
+	mc.bands = c
+	mc.deltaBands <- c
+	return c, nil
+}
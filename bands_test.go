@@ -0,0 +1,138 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestComputeBandPowers(t *testing.T) {
+	rate := 250
+	x := make([]float64, 1000)
+	for i := range x {
+		ts := float64(i) / float64(rate)
+		x[i] = 10*math.Sin(2*math.Pi*10*ts) + 4*math.Sin(2*math.Pi*20*ts)
+	}
+	for _, scale := range []string{scalePSD, scaleDB} {
+		c := PSDConfig{Window: "hann", Segment: 250, Overlap: 0.5, Scale: scale}
+		flat := make([]float64, 125)
+		if scale == scaleDB {
+			for k := range flat {
+				flat[k] = dbFloor
+			}
+		}
+		psd := map[string][]float64{"Chan1": welch(x, rate, c), "Chan2": flat}
+		bp := computeBandPowers(psd, 1, scale, defaultBandConfig, 2)
+		//alpha holds the 50 µV² of the 10 Hz sine, beta the 8 µV² at 20 Hz
+		alpha, beta := bp.absolute[0][2], bp.absolute[0][3]
+		if math.Abs(alpha-50) > 1.5 || math.Abs(beta-8) > 0.5 ||
+			math.Abs(bp.relative[0][2]-50.0/58) > 0.01 || bp.relative[0][0] > 0.01 {
+			t.Error("For", scale, "expected alpha 50 and beta 8 got", bp.absolute[0], bp.relative[0])
+		}
+		if bp.absolute[1][2] != 0 || bp.relative[1][2] != 0 {
+			t.Error("For", scale, "and a flat channel expected no power got", bp.absolute[1], bp.relative[1])
+		}
+		m := bp.payload()
+		if len(m) != 10 || m["alpha"][0] != alpha || m["betaRel"][0] != bp.relative[0][3] || len(m["gamma"]) != 2 {
+			t.Error("For", scale, "expected a payload of 10 bands got", m)
+		}
+	}
+}
+
+type testbandconfigpair struct {
+	config BandConfig
+	ok     bool
+}
+
+var testsbandconfig = []testbandconfigpair{
+	{defaultBandConfig, true},
+	{BandConfig{Bands: []Band{{"smr", 12, 15}}, Rate: 10, Log: true}, true},
+	{BandConfig{}, true},
+	{BandConfig{Rate: 1}, false},
+	{BandConfig{Bands: []Band{{"smr", 12, 15}}, Rate: maxBandRate + 1}, false},
+	{BandConfig{Bands: []Band{{"smr", 15, 12}}, Rate: 1}, false},
+	{BandConfig{Bands: []Band{{"", 12, 15}}, Rate: 1}, false},
+	{BandConfig{Bands: []Band{{"a", 1, 4}, {"aRel", 4, 8}}, Rate: 1}, false},
+	{BandConfig{Bands: []Band{{"a", 1, 4}, {"a", 4, 8}}, Rate: 1}, false},
+}
+
+func TestBandConfigValidate(t *testing.T) {
+	for _, pair := range testsbandconfig {
+		if err := pair.config.validate(); (err == nil) != pair.ok {
+			t.Error("For", pair.config, "expected ok", pair.ok, "got", err)
+		}
+	}
+	for rate, every := range map[float64]int{0: 0, 1: 250, 4: 63, 50: 5} {
+		if res := (BandConfig{Rate: rate}).every(250); res != every {
+			t.Error("For", rate, "per second at 250 Hz expected every", every, "got", res)
+		}
+	}
+}
+
+func TestSetBandsWhileSaving(t *testing.T) {
+	mc := NewMindControl(nil, nil, nil, nil, Cyton, RecoverRepeat)
+	mc.saving = true
+	c := defaultBandConfig
+	c.Log = true
+	if _, err := mc.SetBands(c); err != errSavingBands {
+		t.Error("For band logging switched on while saving expected", errSavingBands, "got", err)
+	}
+	go func() { <-mc.deltaBands }()
+	c = defaultBandConfig
+	c.Rate = 4
+	if res, err := mc.SetBands(c); err != nil || res.Rate != 4 || mc.bands.Rate != 4 {
+		t.Error("For a new rate while saving without logging expected 4 got", err, res)
+	}
+}
+
+func TestBandLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bands")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	w, err := newBDFWriter(dir+"/", Cyton.newGains())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	bp := bandPowers{
+		time:     time.Unix(1, 0),
+		bands:    []Band{{"alpha", 8, 13}},
+		absolute: [][]float64{{50}, {2}},
+		relative: [][]float64{{0.5}, {0.25}},
+	}
+	if err = w.writeBands(bp); err != nil {
+		t.Fatal(err)
+	}
+	outfn := dir + "/1.edf"
+	if err = w.finish(outfn, time.Unix(0, 0), time.Unix(2, 0)); err != nil {
+		t.Fatal(err)
+	}
+	log, err := ioutil.ReadFile(dir + "/1.bands.csv")
+	want := "time,channel,band,low,high,absolute,relative\n" +
+		"1000000000,1,alpha,8,13,50,0.5\n1000000000,2,alpha,8,13,2,0.25\n"
+	if err != nil || string(log) != want {
+		t.Error("For the band log expected", want, "got", err, string(log))
+	}
+}
@@ -0,0 +1,154 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/kevinjos/openbci-driver"
+)
+
+var errSavingBoard = errors.New("cannot reset the board while saving")
+
+//FirmwareInfo is what the board says about itself after a reset
+type FirmwareInfo struct {
+	Board         string
+	Channels      int
+	Daisy         bool
+	Version       string
+	Major         int
+	ADSIDs        []string
+	Accelerometer string `json:",omitempty"`
+	SampleRate    bool
+	Banner        string
+}
+
+//parseBanner reads the reset banner of a Cyton, e.g.
+//
+//	OpenBCI V3 8-16 channel
+//	On Board ADS1299 Device ID: 0x3E
+//	On Daisy ADS1299 Device ID: 0x3E
+//	LIS3DH Device ID: 0x33
+//	Firmware: v3.1.2
+//
+//The daisy line is only there with the daisy attached. Firmware v1 has
+//no version line and no sample rate command.
+func parseBanner(banner string) (FirmwareInfo, error) {
+	info := FirmwareInfo{Major: 1, Banner: banner}
+	for _, line := range strings.Split(banner, "\n") {
+		line = strings.TrimSpace(line)
+		id := ""
+		if i := strings.LastIndex(line, "ID:"); i >= 0 {
+			id = strings.TrimSpace(line[i+3:])
+		}
+		switch {
+		case strings.HasPrefix(line, "OpenBCI "):
+			info.Board = strings.TrimPrefix(line, "OpenBCI ")
+		case strings.HasPrefix(line, "On Board ADS1299"):
+			info.ADSIDs = append([]string{id}, info.ADSIDs...)
+		case strings.HasPrefix(line, "On Daisy ADS1299"):
+			info.ADSIDs = append(info.ADSIDs, id)
+			info.Daisy = true
+		case strings.HasPrefix(line, "LIS3DH"):
+			info.Accelerometer = id
+		case strings.HasPrefix(line, "Firmware:"):
+			info.Version = strings.TrimSpace(strings.TrimPrefix(line, "Firmware:"))
+			major, err := strconv.Atoi(strings.SplitN(strings.TrimPrefix(info.Version, "v"), ".", 2)[0])
+			if err != nil {
+				return info, fmt.Errorf("bad firmware version %q", info.Version)
+			}
+			info.Major = major
+		}
+	}
+	if info.Board == "" || len(info.ADSIDs) == 0 {
+		return info, errors.New("no OpenBCI board in reset banner")
+	}
+	info.Channels = 8 * len(info.ADSIDs)
+	info.SampleRate = info.Major >= 2
+	return info, nil
+}
+
+//board is the Board that info describes, running at the rate current
+//runs at, halved or doubled as the daisy comes or goes
+func (info FirmwareInfo) board(current Board) Board {
+	board := Cyton
+	if info.Daisy {
+		board = CytonDaisy
+	}
+	hz := current.SamplesPerSecond
+	if current.Daisy {
+		hz *= 2
+	}
+	board.SamplesPerSecond = hz
+	if board.Daisy {
+		board.SamplesPerSecond /= 2
+	}
+	return board
+}
+
+//Identify resets the board and reads its banner, which like the
+//register dump only comes while the board is not streaming. When the
+//board turns out to be another than the one configured the decoder and
+//the filter and FFT stages are switched over to it. The reset puts the
+//channel settings back to their defaults, so the gains follow. A
+//recording would not survive that, so there is no reset while saving.
+func (mc *MindControl) Identify() (FirmwareInfo, error) {
+	if mc.isStreaming() {
+		return FirmwareInfo{}, errStreaming
+	}
+	if mc.saving {
+		return FirmwareInfo{}, errSavingBoard
+	}
+	resp, err := mc.Execute(Command{
+		Bytes:    string(openbci.Command["reset"]),
+		Pause:    true,
+		Response: true,
+		Timeout:  resetTimeout,
+	})
+	if err != nil {
+		return FirmwareInfo{}, err
+	}
+	info, err := parseBanner(resp)
+	if err != nil {
+		return info, err
+	}
+	mc.mu.Lock()
+	board := info.board(mc.board)
+	changed := board != mc.board
+	if changed {
+		glog.Infof("board reports %s with %d channels, switching from %s\n", info.Board, info.Channels, mc.board.Name)
+		mc.board = board
+		mc.resizeFilters(board.Channels)
+	}
+	mc.firmware = &info
+	mc.config = nil
+	mc.gain = board.newGains()
+	mc.mu.Unlock()
+	if changed {
+		err = mc.sendBoard(board)
+		if err != nil {
+			return info, err
+		}
+	}
+	return info, mc.sendGains(board.newGains())
+}
@@ -0,0 +1,130 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+type testbannerpair struct {
+	banner   string
+	channels int
+	daisy    bool
+	major    int
+	ok       bool
+}
+
+var testsbanner = []testbannerpair{
+	{firmwareBanner(false), 8, false, 3, true},
+	{firmwareBanner(true), 16, true, 3, true},
+	{"OpenBCI V3 8-16 channel\nOn Board ADS1299 Device ID: 0x3E\nLIS3DH Device ID: 0x33\n", 8, false, 1, true},
+	{"\xa0\x01garbage\nOpenBCI V3 8-16 channel\nOn Board ADS1299 Device ID: 0x3E\nFirmware: v2.0.1\n", 8, false, 2, true},
+	{"OpenBCI V3 8-16 channel\nOn Board ADS1299 Device ID: 0x3E\nFirmware: vX\n", 0, false, 0, false},
+	{"Board ADS Registers\n", 0, false, 0, false},
+}
+
+func TestParseBanner(t *testing.T) {
+	for _, pair := range testsbanner {
+		info, err := parseBanner(pair.banner)
+		if (err == nil) != pair.ok {
+			t.Error("For", pair.banner, "expected ok", pair.ok, "got", err)
+			continue
+		}
+		if pair.ok && (info.Channels != pair.channels || info.Daisy != pair.daisy || info.Major != pair.major ||
+			info.SampleRate != (pair.major >= 2)) {
+			t.Error(
+				"For", pair.banner,
+				"expected", pair.channels, pair.daisy, pair.major,
+				"got", info.Channels, info.Daisy, info.Major, info.SampleRate,
+			)
+		}
+	}
+}
+
+func TestIdentifySwitchesBoard(t *testing.T) {
+	g := NewSignalGenerator(DefaultGeneratorConfig(CytonDaisy), make(chan bool))
+	board := Cyton
+	board.SamplesPerSecond = 1000
+	mc := NewMindControl(nil, nil, g, nil, board, RecoverRepeat)
+	go mc.runCommands()
+	defer close(mc.quitDecodeStream)
+	boards := make(chan Board, 2)
+	go func() {
+		resume := <-mc.pauseRead
+		<-resume
+		boards <- <-mc.boardC
+		boards <- <-mc.deltaBoard
+		<-mc.gainC
+	}()
+	info, err := mc.Identify()
+	if err != nil || !info.Daisy || mc.firmware == nil {
+		t.Fatal("For a daisy generator expected a daisy banner got", err, info)
+	}
+	for _, b := range []Board{mc.board, <-boards, <-boards} {
+		if b.Name != CytonDaisy.Name || b.Channels != 16 || b.SamplesPerSecond != 500 {
+			t.Error("For a daisy generator at 1000 Hz expected daisy at 500 got", b)
+		}
+	}
+	if len(mc.gain) != 16 {
+		t.Error("For a daisy generator expected 16 gains got", len(mc.gain))
+	}
+}
+
+func TestSetSampleRateFirmwareV1(t *testing.T) {
+	device := &testDevice{r: bytes.NewReader(nil)}
+	mc := NewMindControl(nil, nil, device, nil, Cyton, RecoverRepeat)
+	mc.firmware = &FirmwareInfo{Major: 1}
+	if err := mc.SetSampleRate(500); err == nil || device.written.Len() != 0 {
+		t.Error("For firmware v1 expected the rate refused got", err, device.written.String())
+	}
+}
+
+func TestIdentifyRefused(t *testing.T) {
+	device := &testDevice{r: bytes.NewReader(nil)}
+	mc := NewMindControl(nil, nil, device, nil, Cyton, RecoverRepeat)
+	go mc.runCommands()
+	defer close(mc.quitDecodeStream)
+	mc.saving = true
+	if _, err := mc.Identify(); err != errSavingBoard || device.written.Len() != 0 {
+		t.Error("For a reset while saving expected", errSavingBoard, "and nothing written got", err, device.written.String())
+	}
+}
+
+//TestIdentifyDecoderGone has the decoder stop right after the reset, the
+//filter and FFT stages are switched all the same
+func TestIdentifyDecoderGone(t *testing.T) {
+	g := NewSignalGenerator(DefaultGeneratorConfig(CytonDaisy), make(chan bool))
+	mc := NewMindControl(nil, nil, g, nil, Cyton, RecoverRepeat)
+	go mc.runCommands()
+	defer close(mc.quitDecodeStream)
+	boards := make(chan Board, 1)
+	go func() {
+		resume := <-mc.pauseRead
+		<-resume
+		boards <- <-mc.deltaBoard
+	}()
+	_, err := mc.Identify()
+	if err != errDecoderPaused {
+		t.Error("For a decoder gone expected", errDecoderPaused, "got", err)
+	}
+	if b := <-boards; b.Channels != 16 || mc.currentBoard().Channels != 16 {
+		t.Error("For a daisy generator expected 16 channels got", b, mc.currentBoard())
+	}
+}
@@ -0,0 +1,235 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kevinjos/eeg-web-server/int24"
+	"github.com/kevinjos/goedf"
+)
+
+//bdfWriter collects a recording in one temporary file per channel plus
+//one for the timestamps and assembles the BDF from them once finished.
+//The BDF holds a single data record, so the header can only be written
+//when the number of samples is known. The samples are stored unfiltered,
+//notch notes the mains filter of the live view in the recording
+//identification as it is not in the samples.
+type bdfWriter struct {
+	tmpdir          string
+	gains           []float64
+	notch           string
+	files           []*os.File
+	tsfile          *os.File
+	bandsfile       *os.File
+	ns              int
+	firstts, lastts time.Time
+}
+
+//newBDFWriter starts a recording in dir with one channel per gain
+func newBDFWriter(dir string, gains []float64) (*bdfWriter, error) {
+	w := &bdfWriter{
+		tmpdir: dir + strconv.FormatInt(time.Now().UnixNano(), 10),
+		gains:  append([]float64(nil), gains...),
+		files:  make([]*os.File, len(gains)),
+	}
+	err := os.MkdirAll(w.tmpdir, 0777)
+	if err != nil {
+		return nil, err
+	}
+	for i := range w.files {
+		fn := "chan" + strconv.Itoa(i)
+		w.files[i], err = os.Create(w.tmpdir + "/" + fn)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+	w.tsfile, err = os.Create(w.tmpdir + "/timestamps")
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+//write appends the sample in p
+func (w *bdfWriter) write(p *Packet) {
+	w.ns++
+	for idx, c := range p.Counts {
+		w.files[idx].Write(int24.MarshalSLE(c))
+	}
+	if w.firstts.IsZero() {
+		w.firstts = p.Timestamp
+	}
+	w.lastts = p.Timestamp
+	fmt.Fprintf(w.tsfile, "%d,%d,%d,%d,%t\n", p.seqNum, p.Timestamp.UnixNano(),
+		p.Received.UnixNano(), p.Lost, p.Synthesized)
+}
+
+//writeBands appends the band powers in bp to the band power log, which
+//is started on first use
+func (w *bdfWriter) writeBands(bp bandPowers) error {
+	if w.bandsfile == nil {
+		var err error
+		w.bandsfile, err = os.Create(w.tmpdir + "/bands")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w.bandsfile, "time,channel,band,low,high,absolute,relative")
+	}
+	for ch := range bp.absolute {
+		for j, b := range bp.bands {
+			_, err := fmt.Fprintf(w.bandsfile, "%d,%d,%s,%g,%g,%g,%g\n", bp.time.UnixNano(), ch+1,
+				b.Name, b.Low, b.High, bp.absolute[ch][j], bp.relative[ch][j])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//recordID is the EDF+ recording identification. A note on the mains
+//notch goes after the admin code, technician and equipment, which are
+//not known and given as X.
+func recordID(start time.Time, notch string) string {
+	id := "Startdate " + strings.ToUpper(start.Format("02-Jan-2006"))
+	if notch != "" {
+		id += " X X X " + notch
+	}
+	return id
+}
+
+//finish writes the BDF to outfn and the timestamps next to it as
+//.ts.csv. startts and endts are the host times the recording was started
+//and stopped; the sample timestamps are preferred where there are any.
+//A band power log is written next to it as .bands.csv.
+func (w *bdfWriter) finish(outfn string, startts, endts time.Time) error {
+	channels := len(w.gains)
+	// crunch know EDF header quantities
+	version := "\xffBIOSEMI"
+	numbytes := strconv.Itoa(biosigio.FixedHeaderBytes + biosigio.VariableHeaderBytes*channels)
+	reserved := "24BIT"
+	numsignals := strconv.Itoa(channels)
+	numdatar := "1"
+	phydims := make([]string, channels)
+	phymins := make([]string, channels)
+	phymaxs := make([]string, channels)
+	digmins := make([]string, channels)
+	digmaxs := make([]string, channels)
+	nsreserved := make([]string, channels)
+	for idx, val := range w.gains {
+		phydims[idx] = "uv"
+		phymins[idx] = strconv.FormatFloat(scaleToMicroVolts(-8388608, val), 'f', 0, 64)
+		phymaxs[idx] = strconv.FormatFloat(scaleToMicroVolts(8388607, val), 'f', 0, 64)
+		digmins[idx] = "-8388608"
+		digmaxs[idx] = "8388607"
+		nsreserved[idx] = "3"
+	}
+	span := endts.Sub(startts)
+	if w.ns > 1 && !w.firstts.IsZero() {
+		//the sample clock covers ns-1 sample periods, add the last
+		startts = w.firstts
+		span = w.lastts.Sub(w.firstts) * time.Duration(w.ns) / time.Duration(w.ns-1)
+	}
+	LRID := recordID(startts, w.notch)
+	startdate := startts.Format("02.01.06")
+	starttime := startts.Format("15.04.05")
+	duration := strconv.FormatFloat(span.Seconds(), 'f', 3, 64)
+	numsamples := make([]string, channels)
+	for idx := range numsamples {
+		numsamples[idx] = strconv.Itoa(w.ns)
+	}
+	h, err := biosigio.NewHeader(biosigio.Version(version),
+		biosigio.LocalRecordID(LRID),
+		biosigio.Startdate(startdate),
+		biosigio.Starttime(starttime),
+		biosigio.NumBytes(numbytes),
+		biosigio.Reserved(reserved),
+		biosigio.NumDataRecord(numdatar),
+		biosigio.Duration(duration),
+		biosigio.NumSignal(numsignals),
+		biosigio.PhysicalDimensions(phydims),
+		biosigio.PhysicalMaxs(phymaxs),
+		biosigio.PhysicalMins(phymins),
+		biosigio.DigitalMaxs(digmaxs),
+		biosigio.DigitalMins(digmins),
+		biosigio.NumSamples(numsamples),
+		biosigio.NSReserved(nsreserved))
+	if err != nil {
+		return err
+	}
+	bdf := biosigio.NewBDF(h, []*biosigio.BDFData{})
+	buf, err := biosigio.MarshalBDF(bdf)
+	if err != nil {
+		return err
+	}
+	err = copyFile(outfn[:len(outfn)-len(".edf")]+".ts.csv", w.tsfile)
+	if err != nil {
+		return err
+	}
+	if w.bandsfile != nil {
+		err = copyFile(outfn[:len(outfn)-len(".edf")]+".bands.csv", w.bandsfile)
+		if err != nil {
+			return err
+		}
+	}
+	outfd, err := os.Create(outfn)
+	if err != nil {
+		return err
+	}
+	defer outfd.Close()
+	_, err = outfd.Write(buf)
+	if err != nil {
+		return err
+	}
+	for _, fd := range w.files {
+		_, err = fd.Seek(0, 0)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(outfd, fd)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Close removes the temporary files
+func (w *bdfWriter) Close() error {
+	for _, f := range w.files {
+		if f != nil {
+			f.Close()
+		}
+	}
+	if w.tsfile != nil {
+		w.tsfile.Close()
+	}
+	if w.bandsfile != nil {
+		w.bandsfile.Close()
+	}
+	return os.RemoveAll(w.tmpdir)
+}
@@ -0,0 +1,63 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+//Board describes the channel layout and the effective sample rate
+//of the OpenBCI hardware feeding the server
+type Board struct {
+	Name             string
+	Channels         int
+	SamplesPerSecond int
+	Daisy            bool
+}
+
+var (
+	//Cyton is the 8 channel board streaming one sample per frame
+	Cyton = Board{
+		Name:             "cyton",
+		Channels:         8,
+		SamplesPerSecond: samplesPerSecond,
+	}
+	//CytonDaisy is the Cyton with the Daisy module attached. Frames with
+	//odd sequence numbers carry channels 1-8 and are joined with the
+	//following even frame carrying channels 9-16, halving the sample rate
+	CytonDaisy = Board{
+		Name:             "daisy",
+		Channels:         16,
+		SamplesPerSecond: samplesPerSecond / 2,
+		Daisy:            true,
+	}
+)
+
+//Channel identifiers as used by the firmware: the x...X settings
+//command, turning a channel on and turning a channel off
+const (
+	channelIDs  = "12345678QWERTYUI"
+	channelsOn  = "!@#$%^&*QWERTYUI"
+	channelsOff = "12345678qwertyui"
+)
+
+//newGains returns the default gain of 24 for every channel on the board
+func (b Board) newGains() []float64 {
+	gains := make([]float64, b.Channels)
+	for i := range gains {
+		gains[i] = 24.0
+	}
+	return gains
+}
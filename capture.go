@@ -0,0 +1,224 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+//A capture file starts with captureMagic and then holds one record per
+//chunk that passed through the device: the direction, the host time in
+//unix nanoseconds and the length as little endian int64 and uint32, and
+//the bytes themselves.
+const (
+	captureMagic      = "OBCICAP1"
+	captureHeaderSize = 13
+	captureRead       = 'R'
+	captureWrite      = 'W'
+)
+
+var errCapturing = errors.New("capture already running")
+
+//Capture records every chunk read from and written to the device
+type Capture struct {
+	mu  sync.Mutex
+	w   io.WriteCloser
+	err error
+}
+
+//NewCapture starts a capture file on w
+func NewCapture(w io.WriteCloser) (*Capture, error) {
+	_, err := io.WriteString(w, captureMagic)
+	if err != nil {
+		return nil, err
+	}
+	return &Capture{w: w}, nil
+}
+
+//record appends p, stamped with the time now. A failing write is logged
+//once and ends the capture without disturbing the device.
+func (c *Capture) record(dir byte, p []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	var head [captureHeaderSize]byte
+	head[0] = dir
+	binary.LittleEndian.PutUint64(head[1:9], uint64(time.Now().UnixNano()))
+	binary.LittleEndian.PutUint32(head[9:13], uint32(len(p)))
+	_, c.err = c.w.Write(head[:])
+	if c.err == nil {
+		_, c.err = c.w.Write(p)
+	}
+	if c.err != nil {
+		glog.Errorf("error writing capture, capture stopped: %s\n", c.err)
+	}
+}
+
+func (c *Capture) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.w.Close()
+}
+
+//captureRecord is one chunk read back from a capture file
+type captureRecord struct {
+	Dir  byte
+	Time time.Time
+	Data []byte
+}
+
+func readCaptureRecord(r io.Reader) (captureRecord, error) {
+	var head [captureHeaderSize]byte
+	_, err := io.ReadFull(r, head[:])
+	if err != nil {
+		return captureRecord{}, err
+	}
+	rec := captureRecord{
+		Dir:  head[0],
+		Time: time.Unix(0, int64(binary.LittleEndian.Uint64(head[1:9]))),
+		Data: make([]byte, binary.LittleEndian.Uint32(head[9:13])),
+	}
+	_, err = io.ReadFull(r, rec.Data)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return rec, err
+}
+
+//CaptureDevice plays the bytes read in a capture back chunk for chunk,
+//so the decoder sees the reads the way it saw them in the field. In
+//real time mode every chunk is held back until its offset from the
+//first one has passed. Writes are accepted and discarded.
+type CaptureDevice struct {
+	r        *bufio.Reader
+	c        io.Closer
+	realtime bool
+	first    time.Time
+	start    time.Time
+	pending  []byte
+}
+
+//NewCaptureDevice plays back the capture on r
+func NewCaptureDevice(r io.ReadCloser, realtime bool) (*CaptureDevice, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(captureMagic))
+	_, err := io.ReadFull(br, magic)
+	if err != nil || string(magic) != captureMagic {
+		return nil, errors.New("not a capture file")
+	}
+	return &CaptureDevice{r: br, c: r, realtime: realtime}, nil
+}
+
+//OpenCapture plays back the capture file at path in real time
+func OpenCapture(path string) (*CaptureDevice, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	d, err := NewCaptureDevice(f, true)
+	if err != nil {
+		f.Close()
+	}
+	return d, err
+}
+
+func (d *CaptureDevice) Read(p []byte) (int, error) {
+	for len(d.pending) == 0 {
+		rec, err := readCaptureRecord(d.r)
+		if err == io.EOF && d.realtime {
+			//at the end behave like an idle serial port rather than spin
+			time.Sleep(streamReadTimeout)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if rec.Dir != captureRead {
+			continue
+		}
+		if d.realtime {
+			if d.first.IsZero() {
+				d.first, d.start = rec.Time, time.Now()
+			}
+			time.Sleep(d.start.Add(rec.Time.Sub(d.first)).Sub(time.Now()))
+		}
+		d.pending = rec.Data
+	}
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
+
+func (d *CaptureDevice) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (d *CaptureDevice) Close() error {
+	return d.c.Close()
+}
+
+//StartCapture records the device traffic to a new capture file in data/
+//and returns its name
+func (mc *MindControl) StartCapture() (string, error) {
+	if mc.capturing {
+		return "", errCapturing
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	wd += "/" + mc.dataDir + "/"
+	err = os.MkdirAll(wd, 0777)
+	if err != nil {
+		return "", err
+	}
+	fn := wd + strconv.FormatInt(time.Now().Unix(), 10) + ".cap"
+	f, err := os.Create(fn)
+	if err != nil {
+		return "", err
+	}
+	c, err := NewCapture(f)
+	if err != nil {
+		f.Close()
+		return "", err
+	}
+	mc.device.setCapture(c)
+	mc.capturing = true
+	return fn, nil
+}
+
+//StopCapture ends the running capture and closes its file
+func (mc *MindControl) StopCapture() error {
+	c := mc.device.setCapture(nil)
+	mc.capturing = false
+	if c == nil {
+		return nil
+	}
+	return c.Close()
+}
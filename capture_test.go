@@ -0,0 +1,108 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+//captureStream reads stream through a capturing device in reads of
+//uneven size and returns the capture file and the chunks read
+func captureStream(t *testing.T, stream []byte) ([]byte, [][]byte) {
+	var buf bytes.Buffer
+	c, err := NewCapture(nopWriteCloser{&buf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd := newSwappableDevice(&testDevice{r: iotest.HalfReader(bytes.NewReader(stream))})
+	sd.setCapture(c)
+	sd.Write([]byte{'b'})
+	var chunks [][]byte
+	p := make([]byte, 50)
+	for {
+		n, err := sd.Read(p)
+		if n > 0 {
+			chunks = append(chunks, append([]byte(nil), p[:n]...))
+		}
+		if err != nil {
+			break
+		}
+	}
+	return buf.Bytes(), chunks
+}
+
+func TestCaptureDevice(t *testing.T) {
+	capture, chunks := captureStream(t, testStream(10))
+	d, err := NewCaptureDevice(ioutil.NopCloser(bytes.NewReader(capture)), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 1024)
+	for i, chunk := range chunks {
+		n, err := d.Read(p)
+		if err != nil || !bytes.Equal(p[:n], chunk) {
+			t.Error("For", "chunk", i, "expected", chunk, "got", p[:n], err)
+		}
+	}
+	if n, err := d.Read(p); n != 0 || err != io.EOF {
+		t.Error("For", "end of capture", "expected", io.EOF, "got", n, err)
+	}
+	_, err = NewCaptureDevice(ioutil.NopCloser(bytes.NewReader(testStream(1))), false)
+	if err == nil {
+		t.Error("For", "frames without a capture header", "expected", "error", "got", err)
+	}
+}
+
+//TestCaptureDecode plays a desynced capture back through DecodeStream
+func TestCaptureDecode(t *testing.T) {
+	stream := testStream(10)
+	//a frame cut short by a dropped byte
+	stream = append(stream[:5*frameSize+10], stream[5*frameSize+11:]...)
+	capture, _ := captureStream(t, stream)
+	d, err := NewCaptureDevice(ioutil.NopCloser(bytes.NewReader(capture)), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mc := NewMindControl(nil, nil, d, nil, Cyton, RecoverDrop)
+	go DecodeStream(mc.PacketChan, mc.gainC, mc.boardC, mc.quitDecodeStream, mc.pauseRead, mc.deviceErr,
+		mc.SerialDevice, mc.board, nil, mc.recovery)
+	defer close(mc.quitDecodeStream)
+	//the first three frames are skipped while syncing and frame 5 is lost
+	for _, expected := range []byte{3, 4, 6, 7, 8, 9} {
+		select {
+		case p := <-mc.PacketChan:
+			if p.seqNum != expected {
+				t.Error("For", "packet", expected, "expected", expected, "got", p.seqNum)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("For", "packet", expected, "expected", expected, "got", "timeout")
+		}
+	}
+}
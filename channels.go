@@ -0,0 +1,129 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+//defaultChannelSettings is the state of every channel after a reset
+func defaultChannelSettings(channels int) []ChannelSettings {
+	settings := make([]ChannelSettings, channels)
+	for i := range settings {
+		settings[i] = ChannelSettings{Channel: i + 1, Gain: 24, InputType: "normal", Bias: true, SRB2: true}
+	}
+	return settings
+}
+
+//validate checks c can be applied to a board with the given number of
+//channels
+func (c ChannelSettings) validate(channels int) error {
+	if c.Channel < 1 || c.Channel > channels {
+		return fmt.Errorf("channel %d out of range 1-%d", c.Channel, channels)
+	}
+	gainOK := false
+	for _, g := range gainCodes {
+		gainOK = gainOK || g == c.Gain
+	}
+	if !gainOK {
+		return fmt.Errorf("channel %d: gain %d not one of %v", c.Channel, c.Gain, gainCodes)
+	}
+	for _, t := range inputTypes {
+		if t == c.InputType {
+			return nil
+		}
+	}
+	return fmt.Errorf("channel %d: input type %q not one of %v", c.Channel, c.InputType, inputTypes)
+}
+
+var errBoardChanged = errors.New("board changed while the settings were written")
+
+//ChannelSettings returns the settings of every channel as last read
+//from or written to the board
+func (mc *MindControl) ChannelSettings() []ChannelSettings {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.channelSettings()
+}
+
+//channelSettings is ChannelSettings with mc.mu held
+func (mc *MindControl) channelSettings() []ChannelSettings {
+	if mc.config == nil {
+		return defaultChannelSettings(mc.board.Channels)
+	}
+	return append([]ChannelSettings(nil), mc.config.Channels...)
+}
+
+//validateChannelSettings checks settings against the board, a channel
+//may only be given once
+func (mc *MindControl) validateChannelSettings(settings []ChannelSettings) error {
+	board := mc.currentBoard()
+	seen := make(map[int]bool)
+	for _, s := range settings {
+		err := s.validate(board.Channels)
+		if err != nil {
+			return err
+		}
+		if seen[s.Channel] {
+			return fmt.Errorf("channel %d given twice", s.Channel)
+		}
+		seen[s.Channel] = true
+	}
+	return nil
+}
+
+//ApplyChannelSettings validates settings and writes them to the board.
+//Once the board took them the decoder is switched to the new gains in
+//one go and the settings of every channel are returned.
+func (mc *MindControl) ApplyChannelSettings(settings []ChannelSettings) ([]ChannelSettings, error) {
+	err := mc.validateChannelSettings(settings)
+	if err != nil {
+		return nil, err
+	}
+	var command string
+	for _, s := range settings {
+		command += s.command()
+	}
+	err = mc.writeCommand(command)
+	if err != nil {
+		return nil, err
+	}
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	channels := mc.channelSettings()
+	for _, s := range settings {
+		if s.Channel > len(channels) {
+			return nil, errBoardChanged
+		}
+		channels[s.Channel-1] = s
+	}
+	config := &BoardConfig{Channels: channels}
+	if mc.config != nil {
+		config.Registers = mc.config.Registers
+	}
+	mc.config = config
+	mc.gain = config.Gains()
+	//still under the lock, the decoder gets the gains in the order set
+	err = mc.sendGains(config.Gains())
+	if err != nil {
+		return nil, err
+	}
+	return mc.channelSettings(), nil
+}
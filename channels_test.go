@@ -0,0 +1,107 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+type testvalidatepair struct {
+	settings ChannelSettings
+	valid    bool
+}
+
+var testsvalidate = []testvalidatepair{
+	{ChannelSettings{Channel: 1, Gain: 24, InputType: "normal"}, true},
+	{ChannelSettings{Channel: 8, Gain: 1, InputType: "bias_drn", PowerDown: true}, true},
+	{ChannelSettings{Channel: 0, Gain: 24, InputType: "normal"}, false},
+	{ChannelSettings{Channel: 9, Gain: 24, InputType: "normal"}, false},
+	{ChannelSettings{Channel: 1, Gain: 3, InputType: "normal"}, false},
+	{ChannelSettings{Channel: 1, Gain: 24, InputType: ""}, false},
+}
+
+func TestValidateChannelSettings(t *testing.T) {
+	for _, pair := range testsvalidate {
+		err := pair.settings.validate(Cyton.Channels)
+		if (err == nil) != pair.valid {
+			t.Error("For", pair.settings, "expected", pair.valid, "got", err)
+		}
+	}
+}
+
+func TestApplyChannelSettings(t *testing.T) {
+	g := NewSignalGenerator(DefaultGeneratorConfig(CytonDaisy), make(chan bool))
+	mc := NewMindControl(nil, nil, g, nil, CytonDaisy, RecoverRepeat)
+	go mc.runCommands()
+	defer close(mc.quitDecodeStream)
+	gains := make(chan []float64, 1)
+	go func() { gains <- <-mc.gainC }()
+
+	settings := []ChannelSettings{
+		{Channel: 2, Gain: 8, InputType: "shorted", SRB2: true},
+		{Channel: 12, Gain: 1, InputType: "testsig", PowerDown: true, SRB1: true},
+	}
+	state, err := mc.ApplyChannelSettings(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := <-gains
+	for ch := 0; ch < 16; ch++ {
+		expected := defaultChannelSettings(16)[ch]
+		switch ch {
+		case 1:
+			expected = settings[0]
+		case 11:
+			expected = settings[1]
+		}
+		if state[ch] != expected || g.settings[ch] != expected {
+			t.Error("For", chanName(ch), "expected", expected, "got", state[ch], "on the board", g.settings[ch])
+		}
+		if decoder[ch] != float64(expected.Gain) || mc.gain[ch] != float64(expected.Gain) {
+			t.Error("For", chanName(ch), "expected gain", expected.Gain, "got", decoder[ch], mc.gain[ch])
+		}
+	}
+
+	_, err = mc.ApplyChannelSettings([]ChannelSettings{settings[0], settings[0]})
+	if err == nil {
+		t.Error("For", "a channel given twice", "expected", "error", "got", err)
+	}
+}
+
+func TestApplyChannelSettingsFailing(t *testing.T) {
+	settings := []ChannelSettings{{Channel: 2, Gain: 8, InputType: "normal"}}
+	//the device is gone, nothing may change
+	mc := NewMindControl(nil, nil, nil, nil, Cyton, RecoverRepeat)
+	go mc.runCommands()
+	defer close(mc.quitDecodeStream)
+	_, err := mc.ApplyChannelSettings(settings)
+	if err != errDeviceDisconnected || mc.ChannelSettings()[1].Gain != 24 || mc.gains()[1] != 24 {
+		t.Error("For an unplugged device expected", errDeviceDisconnected, "and gain 24 got", err,
+			mc.ChannelSettings()[1].Gain, mc.gains()[1])
+	}
+	//the board takes the settings but no decoder picks up the gains
+	mc = NewMindControl(nil, nil, &testDevice{r: bytes.NewReader(nil)}, nil, Cyton, RecoverRepeat)
+	go mc.runCommands()
+	defer close(mc.quitDecodeStream)
+	_, err = mc.ApplyChannelSettings(settings)
+	if err != errDecoderPaused || mc.gains()[1] != 8 {
+		t.Error("For no decoder expected", errDecoderPaused, "and gain 8 kept for the next one got", err, mc.gains()[1])
+	}
+}
@@ -0,0 +1,95 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"time"
+)
+
+//clockMemory is how far back, in seconds, the sample clock fit looks
+const clockMemory = 60
+
+//sampleClock fits the host receive times of the samples against their
+//index with exponentially forgetting least squares. The fitted line gives
+//the true sample rate of the board and timestamps without the jitter of
+//USB batching, where many samples arrive in one read.
+type sampleClock struct {
+	nominal float64
+	decay   float64
+	start   time.Time
+	last    time.Time
+	n       int64
+	//weighted sums of x, the sample index, and y, seconds since start
+	sw, sx, sy, sxx, sxy float64
+}
+
+func newSampleClock(samplesPerSecond int) *sampleClock {
+	return &sampleClock{
+		nominal: float64(samplesPerSecond),
+		decay:   1 - 1/float64(clockMemory*samplesPerSecond),
+	}
+}
+
+//stamp takes the host receive time of the next sample and returns its
+//corrected timestamp. Synthesized samples take a slot on the sample clock
+//but their receive time does not go into the fit.
+func (c *sampleClock) stamp(received time.Time, synthesized bool) time.Time {
+	if c.start.IsZero() {
+		c.start = received
+	}
+	x := float64(c.n)
+	c.n++
+	if !synthesized {
+		y := received.Sub(c.start).Seconds()
+		c.sw = c.decay*c.sw + 1
+		c.sx = c.decay*c.sx + x
+		c.sy = c.decay*c.sy + y
+		c.sxx = c.decay*c.sxx + x*x
+		c.sxy = c.decay*c.sxy + x*y
+	}
+	slope, intercept := c.fit()
+	ts := c.start.Add(time.Duration((intercept + slope*x) * float64(time.Second)))
+	//the fit moves as it learns, never let time run backwards
+	if !ts.After(c.last) && !c.last.IsZero() {
+		ts = c.last.Add(time.Nanosecond)
+	}
+	c.last = ts
+	return ts
+}
+
+//fit returns seconds per sample and the offset of sample zero. Until a
+//second of samples is in, the nominal rate is assumed.
+func (c *sampleClock) fit() (slope float64, intercept float64) {
+	slope = 1 / c.nominal
+	if c.sw == 0 {
+		return slope, 0
+	}
+	mx, my := c.sx/c.sw, c.sy/c.sw
+	varx := c.sxx/c.sw - mx*mx
+	if c.n > int64(c.nominal) && varx > 0 {
+		slope = (c.sxy/c.sw - mx*my) / varx
+	}
+	return slope, my - slope*mx
+}
+
+//Rate is the estimated number of samples per second
+func (c *sampleClock) Rate() float64 {
+	slope, _ := c.fit()
+	return 1 / slope
+}
@@ -0,0 +1,60 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSampleClock(t *testing.T) {
+	const batch = 16
+	trueRate := 250.4
+	c := newSampleClock(250)
+	start := time.Now()
+	period := time.Duration(float64(time.Second) / trueRate)
+	var last time.Time
+	var maxErr time.Duration
+	for n := 0; n < 250*120; n++ {
+		//samples arrive together once the last of a batch is sent
+		received := start.Add(period * time.Duration(n-n%batch+batch-1))
+		ts := c.stamp(received, n%100 == 50)
+		if !ts.After(last) {
+			t.Fatal("timestamps not increasing at sample", n)
+		}
+		last = ts
+		if n > 250*60 {
+			err := ts.Sub(start.Add(period * time.Duration(n)))
+			if err < 0 {
+				err = -err
+			}
+			if err > maxErr {
+				maxErr = err
+			}
+		}
+	}
+	if rate := c.Rate(); math.Abs(rate-trueRate) > 0.01 {
+		t.Error("For rate expected", trueRate, "got", rate)
+	}
+	//the fit carries the mean batching delay but not its jitter
+	if jitter := maxErr - period*(batch-1)/2; jitter > time.Millisecond || jitter < -time.Millisecond {
+		t.Error("For timestamp jitter expected under 1ms got", jitter)
+	}
+}
@@ -0,0 +1,216 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/kevinjos/openbci-driver"
+)
+
+const (
+	//staggerDelay separates the parts of a staggered x...X command so the
+	//firmware's serial buffer keeps up
+	staggerDelay = 10 * time.Millisecond
+	//responseTimeout is how long a command waits for the board's answer
+	//unless it says otherwise
+	responseTimeout = time.Second
+	//resetTimeout leaves the board time to print its banner after a reset
+	resetTimeout = 3 * time.Second
+	//pauseTimeout is how long a command waits for the decoder to pause,
+	//it is not running while the device is being reopened
+	pauseTimeout = time.Second
+)
+
+var (
+	errClosed        = errors.New("device closed")
+	errDecoderPaused = errors.New("decoder not running, device may be reconnecting")
+)
+
+//Command is a request for the board. Pause holds the decoder while the
+//command runs, which it has to when the board's answer is read back.
+type Command struct {
+	Bytes    string
+	Pause    bool
+	Response bool
+	Timeout  time.Duration
+}
+
+//CommandResult reports how a command went along with the board's answer
+type CommandResult struct {
+	OK       bool
+	Response string
+	Error    string `json:",omitempty"`
+	//err keeps the error itself so callers can tell which one it was
+	err error
+}
+
+type commandRequest struct {
+	Command
+	done chan CommandResult
+}
+
+//Execute queues cmd for the board and waits until it has run. Commands
+//run one at a time in the order they were queued. The board's answer up
+//to the $$$ terminator is returned when cmd asks for it.
+func (mc *MindControl) Execute(cmd Command) (string, error) {
+	req := commandRequest{Command: cmd, done: make(chan CommandResult, 1)}
+	select {
+	case mc.commands <- req:
+	case <-mc.quitDecodeStream:
+		return "", errClosed
+	}
+	res := <-req.done
+	return res.Response, res.err
+}
+
+//runCommands is the only writer to the device, it runs the queued
+//commands until MindControl is closed
+func (mc *MindControl) runCommands() {
+	for {
+		select {
+		case <-mc.quitDecodeStream:
+			return
+		case req := <-mc.commands:
+			resp, err := mc.execute(req.Command)
+			res := CommandResult{OK: err == nil, Response: resp, err: err}
+			if err != nil {
+				res.Error = err.Error()
+			}
+			req.done <- res
+		}
+	}
+}
+
+func (mc *MindControl) execute(cmd Command) (string, error) {
+	if cmd.Pause {
+		//a dead decoder fails the command rather than holding up the queue
+		resume := make(chan bool)
+		select {
+		case mc.pauseRead <- resume:
+		case <-mc.quitDecodeStream:
+			return "", errClosed
+		case <-time.After(pauseTimeout):
+			return "", errDecoderPaused
+		}
+		defer func() { resume <- true }()
+	}
+	err := mc.writeStaggered(cmd.Bytes)
+	if err != nil || !cmd.Response {
+		return "", err
+	}
+	timeout := cmd.Timeout
+	if timeout == 0 {
+		timeout = responseTimeout
+	}
+	return readResponse(mc.SerialDevice, timeout)
+}
+
+//writeStaggered writes command to the board, commands made up of 9 byte
+//x...X channel settings are staggered the way the firmware needs them
+func (mc *MindControl) writeStaggered(command string) error {
+	if len(command) < 9 {
+		_, err := mc.SerialDevice.Write([]byte(command))
+		return err
+	}
+	for i := 0; i < len(command); i += 9 {
+		end := i + 9
+		if end > len(command) {
+			end = len(command)
+		}
+		err := mc.staggerWriter(command[i:end])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mc *MindControl) staggerWriter(c string) error {
+	if len(c) < 9 {
+		_, err := mc.SerialDevice.Write([]byte(c))
+		return err
+	}
+	for _, part := range []string{c[0:1], c[1:8], c[8:]} {
+		_, err := mc.SerialDevice.Write([]byte(part))
+		if err != nil {
+			return err
+		}
+		time.Sleep(staggerDelay)
+	}
+	return nil
+}
+
+//sendGains switches the decoder to gains. A decoder that is not running
+//fails it rather than holding up the caller, the one started once the
+//device is back takes mc.gain.
+func (mc *MindControl) sendGains(gains []float64) error {
+	select {
+	case mc.gainC <- gains:
+		return nil
+	case <-mc.quitDecodeStream:
+		return errClosed
+	case <-time.After(pauseTimeout):
+		return errDecoderPaused
+	}
+}
+
+//sendBoard switches the decoder, the way sendGains does, and the filter
+//and FFT stages to board. The stages are switched even when the decoder
+//is not running.
+func (mc *MindControl) sendBoard(board Board) error {
+	var err error
+	select {
+	case mc.boardC <- board:
+	case <-mc.quitDecodeStream:
+		return errClosed
+	case <-time.After(pauseTimeout):
+		err = errDecoderPaused
+	}
+	select {
+	case mc.deltaBoard <- board:
+	case <-mc.quitSendPackets:
+		return errClosed
+	}
+	return err
+}
+
+//setStreaming starts or stops the stream and, once the board took the
+//command, records whether it is streaming
+func (mc *MindControl) setStreaming(on bool) error {
+	command := openbci.Command["stop"]
+	if on {
+		command = openbci.Command["start"]
+	}
+	err := mc.writeCommand(string(command))
+	if err != nil {
+		return err
+	}
+	mc.mu.Lock()
+	mc.streaming = on
+	mc.mu.Unlock()
+	return nil
+}
+
+//writeCommand queues command for the board without waiting for an answer
+func (mc *MindControl) writeCommand(command string) error {
+	_, err := mc.Execute(Command{Bytes: command})
+	return err
+}
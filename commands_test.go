@@ -0,0 +1,130 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//writesDevice keeps every write on its own so staggering shows
+type writesDevice struct {
+	testDevice
+	writes []string
+}
+
+func (d *writesDevice) Write(p []byte) (int, error) {
+	d.writes = append(d.writes, string(p))
+	return len(p), nil
+}
+
+type testcommandpair struct {
+	command string
+	writes  []string
+}
+
+var testscommands = []testcommandpair{
+	{"b", []string{"b"}},
+	{"~4", []string{"~4"}},
+	{"x1060110X", []string{"x", "1060110", "X"}},
+	{"x1060110Xx2160110X", []string{"x", "1060110", "X", "x", "2160110", "X"}},
+}
+
+func TestExecuteStaggers(t *testing.T) {
+	for _, pair := range testscommands {
+		device := &writesDevice{testDevice: testDevice{r: bytes.NewReader(nil)}}
+		mc := NewMindControl(nil, nil, device, nil, Cyton, RecoverRepeat)
+		go mc.runCommands()
+		_, err := mc.Execute(Command{Bytes: pair.command})
+		if err != nil || !reflect.DeepEqual(device.writes, pair.writes) {
+			t.Error("For", pair.command, "expected", pair.writes, "got", err, device.writes)
+		}
+		close(mc.quitDecodeStream)
+	}
+}
+
+func TestExecuteResponse(t *testing.T) {
+	device := &testDevice{r: strings.NewReader("OpenBCI V3 8-16 channel\n$$$\xa0")}
+	mc := NewMindControl(nil, nil, device, nil, Cyton, RecoverRepeat)
+	go mc.runCommands()
+	defer close(mc.quitDecodeStream)
+	paused := make(chan bool, 1)
+	go func() {
+		resume := <-mc.pauseRead
+		paused <- true
+		<-resume
+	}()
+	resp, err := mc.Execute(Command{Bytes: "v", Pause: true, Response: true})
+	if err != nil || resp != "OpenBCI V3 8-16 channel\n" || device.written.String() != "v" || !<-paused {
+		t.Error("For v expected the banner got", err, resp, device.written.String())
+	}
+}
+
+func TestExecuteClosed(t *testing.T) {
+	mc := NewMindControl(nil, nil, &testDevice{r: bytes.NewReader(nil)}, nil, Cyton, RecoverRepeat)
+	close(mc.quitDecodeStream)
+	if _, err := mc.Execute(Command{Bytes: "b"}); err != errClosed {
+		t.Error("For a closed MindControl expected", errClosed, "got", err)
+	}
+}
+
+func TestExecuteWithoutDecoder(t *testing.T) {
+	device := &testDevice{r: bytes.NewReader(nil)}
+	mc := NewMindControl(nil, nil, device, nil, Cyton, RecoverRepeat)
+	go mc.runCommands()
+	defer close(mc.quitDecodeStream)
+	if _, err := mc.Execute(Command{Bytes: "~4", Pause: true}); err != errDecoderPaused {
+		t.Error("For a pause without a decoder expected", errDecoderPaused, "got", err)
+	}
+	if _, err := mc.Execute(Command{Bytes: "b"}); err != nil || device.written.String() != "b" {
+		t.Error("For the next command expected b written got", err, device.written.String())
+	}
+}
+
+func TestStartStopHandler(t *testing.T) {
+	//unplugged, the stream is not started
+	mc := NewMindControl(nil, nil, nil, nil, Cyton, RecoverRepeat)
+	go mc.runCommands()
+	defer close(mc.quitDecodeStream)
+	handle := NewHandle(mc)
+	w := httptest.NewRecorder()
+	handle.startHandler(w, httptest.NewRequest("POST", "/start", nil))
+	if w.Code != 500 || mc.isStreaming() {
+		t.Error("For start while unplugged expected 500 and not streaming got", w.Code, mc.isStreaming())
+	}
+
+	device := &testDevice{r: bytes.NewReader(nil)}
+	mc = NewMindControl(nil, nil, device, nil, Cyton, RecoverRepeat)
+	go mc.runCommands()
+	defer close(mc.quitDecodeStream)
+	handle = NewHandle(mc)
+	w = httptest.NewRecorder()
+	handle.startHandler(w, httptest.NewRequest("POST", "/start", nil))
+	if w.Code != 200 || !mc.isStreaming() || device.writes() != "b" {
+		t.Error("For start expected 200 and streaming got", w.Code, mc.isStreaming(), device.writes())
+	}
+	w = httptest.NewRecorder()
+	handle.stopHandler(w, httptest.NewRequest("POST", "/stop", nil))
+	if w.Code != 200 || mc.isStreaming() || device.writes() != "bs" {
+		t.Error("For stop expected 200 and not streaming got", w.Code, mc.isStreaming(), device.writes())
+	}
+}
@@ -5,94 +5,96 @@ import (
 	"time"
 
 	"github.com/golang/glog"
-	"github.com/kevinjos/openbci-driver"
 )
 
 //DecodeStream implements the openbci packet protocol to
-//assemble packets and sends packet arrays onto the packetStream
-func DecodeStream(packet chan *Packet, gain chan *[8]float64, quit chan bool,
-	pause chan chan bool, device io.ReadWriteCloser) {
+//assemble packets and sends packet arrays onto the packetStream.
+//Samples lost to sequence gaps are replaced according to policy. A read
+//error is reported on errs and ends decoding until the device is reopened.
+//A board sent on boards replaces board, for a new rate or another board.
+//Decoding starts out with gains, or the board's default gains when nil.
+func DecodeStream(packet chan *Packet, gain chan []float64, boards chan Board, quit chan bool,
+	pause chan chan bool, errs chan error, device io.ReadWriteCloser, board Board, gains []float64,
+	policy RecoveryPolicy) {
 	var (
-		b             uint8
-		readstate     uint8
-		thisPacket    [33]byte
-		lastPacket    [33]byte
-		seqDiff       uint8
+		cytonFrame    [33]byte
 		syncPktCtr    uint8
 		syncPktThresh uint8
-		notSyncd      bool
 	)
-	buf := make([]byte, 1)
+	frames := newFramer(device, readBufferSize)
 	syncPktThresh = 2
-	gains := [8]float64{24.0, 24.0, 24.0, 24.0, 24.0, 24.0, 24.0, 24.0}
+	if gains == nil {
+		gains = board.newGains()
+	}
+	gains = append([]float64(nil), gains...)
+	recoverer := newGapRecoverer(policy, board)
+	clock := newSampleClock(board.SamplesPerSecond)
+	var received time.Time
+	//emit sends a frame downstream. With the daisy attached the odd
+	//frame, channels 1-8, is held back until its even partner with
+	//channels 9-16 arrives, unpaired frames are dropped.
+	emit := func(frame *[33]byte) {
+		var p *Packet
+		switch {
+		case !board.Daisy:
+			p = encodePacket(frame, nil, 100, gains, true)
+		case frame[1]%2 == 1:
+			cytonFrame = *frame
+			return
+		case cytonFrame[1] == frame[1]-1:
+			p = encodePacket(&cytonFrame, frame, 100, gains, true)
+		default:
+			return
+		}
+		out := recoverer.recover(p)
+		if p.Lost > 0 {
+			glog.Infof("%d packets behind\n", p.Lost)
+		}
+		for _, q := range out {
+			q.Received = received
+			q.Timestamp = clock.stamp(received, q.Synthesized)
+			if clock.n%int64(clockMemory*board.SamplesPerSecond) == 0 {
+				glog.Infof("estimated sample rate %.3f Hz, nominal %d Hz\n", clock.Rate(), board.SamplesPerSecond)
+			}
+			packet <- q
+		}
+	}
 	for {
 		select {
 		case <-quit:
 			return
 		case g := <-gain:
-			gains = *g
+			gains = g
+		case b := <-boards:
+			if b.Channels != board.Channels || b.Daisy != board.Daisy {
+				gains = b.newGains()
+				recoverer = newGapRecoverer(policy, b)
+				cytonFrame = [33]byte{}
+			}
+			board = b
+			clock = newSampleClock(b.SamplesPerSecond)
 		case resume := <-pause:
 			<-resume
 		default:
-			_, err := device.Read(buf)
+			frame, discarded, err := frames.next()
+			received = time.Now()
 			if err == io.EOF {
 				continue
 			} else if err != nil {
-				glog.Fatalf("error reading from device: %s", err)
-			}
-			b = buf[0]
-			switch readstate {
-			case 0:
-				if b == openbci.Command["footer"] {
-					readstate++
-				}
-			case 1:
-				if b == openbci.Command["header"] {
-					thisPacket[0] = b
-					readstate++
-				} else {
-					readstate = 0
-				}
-			case 2:
-				thisPacket[1] = b
-				seqDiff = difference(b, lastPacket[1])
-				if seqDiff > 1 && syncPktCtr > syncPktThresh {
-					glog.Infof("%d packets behind\n", seqDiff)
-				}
-				for seqDiff > 1 {
-					lastPacket[1]++
-					time.Sleep(4 * time.Millisecond)
-					packet <- encodePacket(&lastPacket, 100-seqDiff, &gains, notSyncd)
-					seqDiff--
-				}
-				fallthrough
-			case 3:
-				for j := 2; j < 32; j++ {
-					_, err = device.Read(buf)
-					if err != nil {
-						glog.Fatalf("error reading from device: %s\n", err)
-					}
-					thisPacket[j] = buf[0]
-				}
-				readstate = 4
-			case 4:
-				switch {
-				case b == openbci.Command["footer"]:
-					thisPacket[32] = b
-					lastPacket = thisPacket
-					if syncPktCtr > syncPktThresh {
-						packet <- encodePacket(&thisPacket, 100, &gains, !notSyncd)
-					} else {
-						syncPktCtr++
-					}
-					readstate = 1
-				case b != openbci.Command["footer"]:
-					readstate = 0
-					fallthrough
-				case syncPktCtr > syncPktThresh:
-					glog.Errorln("Footer out of sync")
+				select {
+				case errs <- err:
+				case <-quit:
 				}
+				return
+			}
+			if syncPktCtr <= syncPktThresh {
+				syncPktCtr++
+				continue
+			}
+			if discarded > 0 {
+				glog.Errorf("Footer out of sync, discarded %d bytes (%d total)\n", discarded, frames.Discarded)
 			}
+			emit(frame)
 		}
 	}
 }
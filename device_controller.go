@@ -19,202 +19,195 @@ package main
 
 import (
 	"io"
+	"math"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
-	"github.com/kevinjos/goedf"
-	"github.com/kevinjos/gofidlib"
 )
 
 // MindControl ...
 type MindControl struct {
 	SerialDevice     io.ReadWriteCloser
+	device           *swappableDevice
+	reopen           DeviceOpener
+	deviceErr        chan error
 	PacketChan       chan *Packet
 	savePacketChan   chan *Packet
-	deltaFFT         chan [2]int
+	saveBandsChan    chan bandPowers
+	impedanceChan    chan Sample
+	deltaFFT         chan fftConfig
+	deltaBands       chan BandConfig
+	deltaBoard       chan Board
+	boardC           chan Board
+	generator        *SignalGenerator
+	realDevice       io.ReadWriteCloser
+	replay           *ReplayDevice
+	firmware         *FirmwareInfo
 	quitGenTest      chan bool
 	quitSendPackets  chan bool
 	quitSave         chan bool
 	quitDecodeStream chan bool
+	closeOnce        sync.Once
 	pauseRead        chan chan bool
-	gainC            chan *[8]float64
+	commands         chan commandRequest
+	filterC          chan filterUpdate
+	gainC            chan []float64
 	shutdown         chan bool
 	broadcast        chan *message
-	gain             [8]float64
+	//mu guards the board state from here to measuring, it is shared by
+	//the handlers, the command queue, superviseDevice and sendPackets.
+	//It is never held while sending to sendPackets.
+	mu               sync.Mutex
+	board            Board
+	dataDir          string
+	recovery         RecoveryPolicy
+	gain             []float64
+	filterSpecs      [][]string
+	notch            MainsNotch
+	bands            BandConfig
+	config           *BoardConfig
 	saving           bool
+	capturing        bool
+	streaming        bool
+	measuring        bool
 	genTesting       bool
 }
 
 // NewMindControl ...
-func NewMindControl(broadcast chan *message, shutdown chan bool, device io.ReadWriteCloser) *MindControl {
+func NewMindControl(broadcast chan *message, shutdown chan bool, device io.ReadWriteCloser,
+	reopen DeviceOpener, board Board, recovery RecoveryPolicy) *MindControl {
 	//Set up the serial device
+	sd := newSwappableDevice(device)
 	return &MindControl{
-		SerialDevice:     device,
+		SerialDevice:     sd,
+		device:           sd,
+		reopen:           reopen,
+		deviceErr:        make(chan error),
 		PacketChan:       make(chan *Packet),
 		savePacketChan:   make(chan *Packet),
-		deltaFFT:         make(chan [2]int),
+		saveBandsChan:    make(chan bandPowers),
+		impedanceChan:    make(chan Sample, 256),
+		deltaFFT:         make(chan fftConfig),
+		deltaBands:       make(chan BandConfig),
+		deltaBoard:       make(chan Board),
+		boardC:           make(chan Board),
 		quitGenTest:      make(chan bool),
 		quitSendPackets:  make(chan bool),
 		quitSave:         make(chan bool),
 		quitDecodeStream: make(chan bool),
 		pauseRead:        make(chan chan bool),
-		gainC:            make(chan *[8]float64),
+		commands:         make(chan commandRequest),
+		filterC:          make(chan filterUpdate),
+		gainC:            make(chan []float64),
 		shutdown:         shutdown,
 		broadcast:        broadcast,
-		gain:             [8]float64{24.0, 24.0, 24.0, 24.0, 24.0, 24.0, 24.0, 24.0},
+		board:            board,
+		dataDir:          "data",
+		recovery:         recovery,
+		gain:             board.newGains(),
+		filterSpecs:      defaultFilterSpecs(board.Channels, MainsNotch{}),
+		bands:            defaultBandConfig,
 		saving:           false,
 		genTesting:       false,
 	}
 }
 
+//currentBoard returns the board being decoded
+func (mc *MindControl) currentBoard() Board {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.board
+}
+
+//gains returns a copy of the gain of every channel
+func (mc *MindControl) gains() []float64 {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return append([]float64(nil), mc.gain...)
+}
+
+func (mc *MindControl) isStreaming() bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.streaming
+}
+
+func (mc *MindControl) isMeasuring() bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.measuring
+}
+
 // Start necessary go routines
 func (mc *MindControl) Start() {
-	go DecodeStream(mc.PacketChan, mc.gainC, mc.quitDecodeStream, mc.pauseRead, mc.SerialDevice)
+	go DecodeStream(mc.PacketChan, mc.gainC, mc.boardC, mc.quitDecodeStream, mc.pauseRead, mc.deviceErr,
+		mc.SerialDevice, mc.board, nil, mc.recovery)
+	go mc.runCommands()
+	go mc.superviseDevice()
 	go mc.sendPackets()
 }
 
-// Close go routines and channels started by MindControl
+// Close go routines and channels started by MindControl, closing it
+// again does nothing
 func (mc *MindControl) Close() {
-	if mc.saving {
-		mc.quitSave <- true
-	}
-	mc.SerialDevice.Close()
-	mc.quitDecodeStream <- true
-	close(mc.quitSendPackets)
-	close(mc.quitGenTest)
-	close(mc.shutdown)
+	mc.closeOnce.Do(func() {
+		if mc.saving {
+			mc.quitSave <- true
+		}
+		close(mc.quitDecodeStream)
+		mc.StopCapture()
+		mc.SerialDevice.Close()
+		if mc.realDevice != nil {
+			mc.realDevice.Close()
+		}
+		close(mc.quitSendPackets)
+		close(mc.quitGenTest)
+		close(mc.shutdown)
+	})
 }
 
 func (mc *MindControl) saveBDF() {
-	var ns int
 	wd, err := os.Getwd()
 	if err != nil {
 		glog.Errorln(err)
 		return
 	}
-	wd += "/data/"
-	files := make([]*os.File, channels)
-	tmpdir := wd + strconv.FormatInt(time.Now().Unix(), 10)
-	err = os.MkdirAll(tmpdir, 0777)
+	wd += "/" + mc.dataDir + "/"
+	w, err := newBDFWriter(wd, mc.gains())
 	if err != nil {
 		glog.Errorln(err)
 		return
 	}
-	for i := 0; i < channels; i++ {
-		fn := "chan" + strconv.Itoa(i)
-		file, err := os.Create(tmpdir + "/" + fn)
-		files[i] = file
-		if err != nil {
-			glog.Errorln(err)
-			return
-		}
-	}
+	mc.mu.Lock()
+	w.notch = mc.notch.note(mc.board.SamplesPerSecond)
+	mc.mu.Unlock()
 	defer func() {
 		mc.saving = false
-		for _, f := range files {
-			f.Close()
-		}
-		err = os.RemoveAll(tmpdir)
+		err = w.Close()
 		if err != nil {
 			glog.Errorln(err)
 		}
 	}()
 	startts := time.Now()
-	// crunch know EDF header quantities
-	version := "\xffBIOSEMI"
-	LRID := "Startdate " + startts.Format("02-JAN-2006")
-	startdate := startts.Format("02.01.06")
-	starttime := startts.Format("15.04.05")
-	numbytes := strconv.Itoa(biosigio.FixedHeaderBytes + biosigio.VariableHeaderBytes*channels)
-	reserved := "24BIT"
-	numsignals := strconv.Itoa(channels)
-	numdatar := "1"
-	phydims := []string{"uv", "uv", "uv", "uv", "uv", "uv", "uv", "uv"}
-	phymins := make([]string, channels)
-	phymaxs := make([]string, channels)
-	nsreserved := make([]string, channels)
-	for idx, val := range mc.gain {
-		phymins[idx] = strconv.FormatFloat(scaleToMicroVolts(-8388608, val), 'f', 0, 64)
-		phymaxs[idx] = strconv.FormatFloat(scaleToMicroVolts(8388607, val), 'f', 0, 64)
-		nsreserved[idx] = "3"
-	}
-	digmins := []string{"-8388608", "-8388608", "-8388608", "-8388608",
-		"-8388608", "-8388608", "-8388608", "-8388608"}
-	digmaxs := []string{"8388607", "8388607", "8388607", "8388607",
-		"8388607", "8388607", "8388607", "8388607"}
 	for {
 		select {
 		case p := <-mc.savePacketChan:
-			ns++
-			val := []byte{p.Rchan1[2], p.Rchan1[1], p.Rchan1[0]}
-			files[0].Write(val)
-			val = []byte{p.Rchan2[2], p.Rchan2[1], p.Rchan2[0]}
-			files[1].Write(val)
-			val = []byte{p.Rchan3[2], p.Rchan3[1], p.Rchan3[0]}
-			files[2].Write(val)
-			val = []byte{p.Rchan4[2], p.Rchan4[1], p.Rchan4[0]}
-			files[3].Write(val)
-			val = []byte{p.Rchan5[2], p.Rchan5[1], p.Rchan5[0]}
-			files[4].Write(val)
-			val = []byte{p.Rchan6[2], p.Rchan6[1], p.Rchan6[0]}
-			files[5].Write(val)
-			val = []byte{p.Rchan7[2], p.Rchan7[1], p.Rchan7[0]}
-			files[6].Write(val)
-			val = []byte{p.Rchan8[2], p.Rchan8[1], p.Rchan8[0]}
-			files[7].Write(val)
-		case <-mc.quitSave:
-			endts := time.Now()
-			duration := strconv.FormatFloat(endts.Sub(startts).Seconds(), 'f', 3, 64)
-			numsamples := make([]string, channels)
-			for idx := range numsamples {
-				numsamples[idx] = strconv.Itoa(ns)
-			}
-			h, err := biosigio.NewHeader(biosigio.Version(version),
-				biosigio.LocalRecordID(LRID),
-				biosigio.Startdate(startdate),
-				biosigio.Starttime(starttime),
-				biosigio.NumBytes(numbytes),
-				biosigio.Reserved(reserved),
-				biosigio.NumDataRecord(numdatar),
-				biosigio.Duration(duration),
-				biosigio.NumSignal(numsignals),
-				biosigio.PhysicalDimensions(phydims),
-				biosigio.PhysicalMaxs(phymaxs),
-				biosigio.PhysicalMins(phymins),
-				biosigio.DigitalMaxs(digmaxs),
-				biosigio.DigitalMins(digmins),
-				biosigio.NumSamples(numsamples),
-				biosigio.NSReserved(nsreserved))
-			if err != nil {
-				glog.Errorln(err)
-			}
-			bdf := biosigio.NewBDF(h, []*biosigio.BDFData{})
-			buf, err := biosigio.MarshalBDF(bdf)
+			w.write(p)
+		case bp := <-mc.saveBandsChan:
+			err = w.writeBands(bp)
 			if err != nil {
 				glog.Errorln(err)
 			}
+		case <-mc.quitSave:
+			endts := time.Now()
 			outfn := wd + strconv.FormatInt(endts.Unix(), 10) + ".edf"
-			outfd, err := os.Create(outfn)
+			err = w.finish(outfn, startts, endts)
 			if err != nil {
 				glog.Errorln(err)
 			}
-			defer outfd.Close()
-			_, err = outfd.Write(buf)
-			if err != nil {
-				glog.Errorln(err)
-			}
-			for _, fd := range files {
-				_, err = fd.Seek(0, 0)
-				if err != nil {
-					glog.Errorln(err)
-				}
-				_, err := io.Copy(outfd, fd)
-				if err != nil {
-					glog.Errorln(err)
-				}
-			}
 			return
 		}
 	}
@@ -225,65 +218,158 @@ func (mc *MindControl) sendPackets() {
 
 	FFTSize := 250
 	FFTFreq := 50
+	psd := defaultPSDConfig
+	bands := mc.bands
+	//fftBins only changes with the fft config or the sample rate
+	var fftBins map[string][]float64
 
-	filterDesign, err := gofidlib.NewFilterDesign("BpBe4/1-30", samplesPerSecond)
-	if err != nil {
-		glog.Fatal("Error creating filter design:", err)
-	}
-
-	filter := make([]*gofidlib.Filter, 8)
-	for j := 0; j < 8; j++ {
-		filter[j] = gofidlib.NewFilter(filterDesign)
-	}
+	mc.mu.Lock()
+	rate := mc.board.SamplesPerSecond
+	rawSize := rawMsgSize(rate)
+	channels := mc.board.Channels
+	filters := newFilterChains(mc.filterSpecs, rate)
+	notch := notchChains(mc.notch, rate, channels)
+	mc.mu.Unlock()
+	pbFFT := NewPacketBatcher(FFTSize, channels)
+	pbRaw := NewPacketBatcher(rawSize, channels)
+	//pbBands holds the samples ahead of the channel filters, a band-pass
+	//there would otherwise hide the bands outside of it
+	pbBands := NewPacketBatcher(FFTSize, channels)
 
 	defer func() {
-		filterDesign.Free()
-		for j := 0; j < 8; j++ {
-			filter[j].Free()
-		}
+		freeFilterChains(filters)
+		freeFilterChains(notch)
+		pbFFT.free()
+		pbBands.free()
 	}()
 
-	pbFFT := NewPacketBatcher(FFTSize)
-	pbRaw := NewPacketBatcher(RawMsgSize)
-
 	for {
 		select {
 		case <-mc.quitSendPackets:
 			return
-		case arr := <-mc.deltaFFT:
-			FFTSize = arr[0]
-			FFTFreq = arr[1]
-			pbFFT = NewPacketBatcher(FFTSize)
+		case c := <-mc.deltaBands:
+			bands = c
+		case c := <-mc.deltaFFT:
+			FFTSize = c.size
+			FFTFreq = c.freq
+			psd = c.psd
+			fftBins = nil
+			pbFFT.free()
+			pbFFT = NewPacketBatcher(FFTSize, channels)
+			pbBands.free()
+			pbBands = NewPacketBatcher(FFTSize, channels)
+			i = 0
+		case u := <-mc.filterC:
+			for ch, c := range u.chains {
+				if u.rate != rate || ch >= channels {
+					//designed for the board before the last change
+					c.free()
+					if ch >= channels {
+						continue
+					}
+					mc.mu.Lock()
+					c = newFilterChains(mc.filterSpecs[ch:ch+1], rate)[0]
+					mc.mu.Unlock()
+				}
+				filters[ch].free()
+				filters[ch] = c
+			}
+			if u.notch != nil {
+				freeFilterChains(notch)
+				notch = u.notch
+				if u.rate != rate || len(notch) != channels {
+					freeFilterChains(notch)
+					mc.mu.Lock()
+					notch = notchChains(mc.notch, rate, channels)
+					mc.mu.Unlock()
+				}
+			}
+		case b := <-mc.deltaBoard:
+			r := b.SamplesPerSecond
+			freeFilterChains(filters)
+			freeFilterChains(notch)
+			mc.mu.Lock()
+			filters = newFilterChains(mc.filterSpecs, r)
+			notch = notchChains(mc.notch, r, b.Channels)
+			mc.mu.Unlock()
+			//keep the FFT window and update interval the same in seconds
+			FFTSize = FFTSize * r / rate
+			FFTFreq = FFTFreq * r / rate
+			if FFTFreq < 1 {
+				FFTFreq = 1
+			}
+			if psd.Segment != 0 {
+				psd.Segment = psd.Segment * r / rate
+				if psd.Segment < 2 {
+					psd.Segment = 0
+				}
+			}
+			rate = r
+			fftBins = nil
+			channels = b.Channels
+			rawSize = rawMsgSize(rate)
+			pbFFT.free()
+			pbFFT = NewPacketBatcher(FFTSize, channels)
+			pbBands.free()
+			pbBands = NewPacketBatcher(FFTSize, channels)
+			pbRaw = NewPacketBatcher(rawSize, channels)
 			i = 0
 		case p := <-mc.PacketChan:
 			if mc.saving == true {
 				mc.savePacketChan <- p
 			}
+			if mc.isMeasuring() {
+				//measure on the unfiltered signal, the filters may stop the tone.
+				//Never block here, the measurement may have just ended.
+				select {
+				case mc.impedanceChan <- p.Sample.Copy():
+				default:
+				}
+			}
 
-			p.Chan1 = filter[0].Run(p.Chan1)
-			p.Chan2 = filter[1].Run(p.Chan2)
-			p.Chan3 = filter[2].Run(p.Chan3)
-			p.Chan4 = filter[3].Run(p.Chan4)
-			p.Chan5 = filter[4].Run(p.Chan5)
-			p.Chan6 = filter[5].Run(p.Chan6)
-			p.Chan7 = filter[6].Run(p.Chan7)
-			p.Chan8 = filter[7].Run(p.Chan8)
+			notched := pbBands.sample(i%FFTSize, channels)
+			for j, val := range p.Microvolts {
+				//keep NaN gaps out of the filter state
+				if !math.IsNaN(val) {
+					val = notch[j].run(val)
+					p.Microvolts[j] = filters[j].run(val)
+				}
+				notched.Microvolts[j] = val
+			}
 
 			pbFFT.packets[i%FFTSize] = p
-			pbRaw.packets[i%RawMsgSize] = p
+			pbRaw.packets[i%rawSize] = p
 
-			if i%RawMsgSize == RawMsgSize-1 {
+			if i%rawSize == rawSize-1 {
 				pbRaw.batch()
 				mc.broadcast <- newMessage("raw", pbRaw.Chans)
+				mc.broadcast <- newMessage("aux", pbRaw.Aux)
 			}
 
-			if i > FFTSize && i%FFTFreq == FFTFreq-1 {
+			fftTick := i > FFTSize && i%FFTFreq == FFTFreq-1
+			every := bands.every(rate)
+			bandTick := every > 0 && i > FFTSize && i%every == every-1
+
+			if fftTick {
 				pbFFT.batch()
-				pbFFT.setFFT()
+				pbFFT.setPSD(rate, psd, true)
 				mc.broadcast <- newMessage("fft", pbFFT.FFTs)
-				binMsg := make(map[string][]float64)
-				binMsg["fftBins"] = calcFFTBins(FFTSize)
-				mc.broadcast <- newMessage("fftBins", binMsg)
+				if fftBins == nil {
+					fftBins = map[string][]float64{"fftBins": calcFFTBins(psd.segment(FFTSize), rate)}
+				}
+				mc.broadcast <- newMessage("fftBins", fftBins)
+			}
+
+			if bandTick {
+				pbBands.batch()
+				pbBands.setPSD(rate, psd, false)
+				df := float64(rate) / float64(psd.segment(FFTSize))
+				bp := computeBandPowers(pbBands.FFTs, df, psd.Scale, bands, channels)
+				bp.time = p.Timestamp
+				mc.broadcast <- newMessage("bands", bp.payload())
+				if mc.saving && bands.Log {
+					mc.saveBandsChan <- bp
+				}
 			}
 
 			i++
@@ -294,6 +380,7 @@ func (mc *MindControl) sendPackets() {
 
 type message struct {
 	Name    string
+	Status  string `json:",omitempty"`
 	Payload map[string][]float64
 }
 
@@ -303,3 +390,11 @@ func newMessage(name string, payload map[string][]float64) *message {
 		Payload: payload,
 	}
 }
+
+func newStatusMessage(status string, payload map[string][]float64) *message {
+	return &message{
+		Name:    "status",
+		Status:  status,
+		Payload: payload,
+	}
+}
@@ -0,0 +1,337 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/kevinjos/openbci-driver"
+)
+
+//DeviceSpec says how to open one board. Location is a serial mount
+//point, a WiFi shield as tcp://host[:port], "replay:" or "capture:"
+//followed by a file to play back, or empty for the synthetic generator.
+type DeviceSpec struct {
+	ID       string
+	Location string
+	Daisy    bool
+}
+
+//ParseDeviceSpecs parses the -devices flag, a comma separated list of
+//id=location entries. A location may end in ?daisy=true.
+func ParseDeviceSpecs(s string) ([]DeviceSpec, error) {
+	var specs []DeviceSpec
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(s, ",") {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || strings.Contains(parts[0], "/") {
+			return nil, fmt.Errorf("bad device %q, expected id=location", entry)
+		}
+		spec := DeviceSpec{ID: parts[0], Location: parts[1]}
+		if i := strings.LastIndex(spec.Location, "?"); i >= 0 {
+			query, err := url.ParseQuery(spec.Location[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("bad options for device %s: %s", spec.ID, err)
+			}
+			if v := query.Get("daisy"); v != "" {
+				spec.Daisy, err = strconv.ParseBool(v)
+				if err != nil {
+					return nil, fmt.Errorf("bad daisy option for device %s: %s", spec.ID, err)
+				}
+			}
+			spec.Location = spec.Location[:i]
+		}
+		if seen[spec.ID] {
+			return nil, fmt.Errorf("device %s given twice", spec.ID)
+		}
+		seen[spec.ID] = true
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+//Device is one board with its own decode, filter and save pipeline and
+//its own hub of websocket sessions
+type Device struct {
+	DeviceSpec
+	mc     *MindControl
+	hub    *hub
+	handle *Handle
+	mux    *http.ServeMux
+}
+
+//DeviceInfo is what the device listing reports about a device
+type DeviceInfo struct {
+	ID               string
+	Location         string
+	Board            string
+	Channels         int
+	SamplesPerSecond int
+	Connected        bool
+	Streaming        bool
+	Saving           bool
+	Capturing        bool
+	GenTesting       bool
+	Replaying        bool
+	Firmware         *FirmwareInfo `json:",omitempty"`
+}
+
+//OpenDevice opens the board described by spec and builds its pipeline
+func OpenDevice(spec DeviceSpec, policy RecoveryPolicy, dataDir string) (*Device, error) {
+	board := Cyton
+	if spec.Daisy {
+		board = CytonDaisy
+	}
+	notch := MainsNotch{Freq: *mains, Harmonics: *harmonics}
+	if err := notch.validate(); err != nil {
+		return nil, err
+	}
+	var (
+		device io.ReadWriteCloser
+		reopen DeviceOpener
+		replay *ReplayDevice
+		err    error
+	)
+	switch {
+	case strings.HasPrefix(spec.Location, "replay:"):
+		rec, err := OpenRecording(strings.TrimPrefix(spec.Location, "replay:"))
+		if err != nil {
+			return nil, fmt.Errorf("error reading recording: %s", err)
+		}
+		board, err = rec.Board()
+		if err != nil {
+			return nil, err
+		}
+		if *speed <= 0 {
+			return nil, errors.New("speed must be positive")
+		}
+		replay = NewReplayDevice(rec, board, *speed, *loop)
+		go replay.Run()
+		device = replay
+	case strings.HasPrefix(spec.Location, "capture:"):
+		device, err = OpenCapture(strings.TrimPrefix(spec.Location, "capture:"))
+		if err != nil {
+			return nil, fmt.Errorf("error opening capture: %s", err)
+		}
+	case strings.HasPrefix(spec.Location, "tcp://"):
+		location := spec.Location
+		reopen = func() (io.ReadWriteCloser, error) {
+			return NewWiFiDevice(location, readTimeout)
+		}
+		device, err = reopen()
+		if err != nil {
+			return nil, fmt.Errorf("error opening wifi shield: %s", err)
+		}
+	case spec.Location != "":
+		location := spec.Location
+		reopen = func() (io.ReadWriteCloser, error) {
+			return openbci.NewDevice(location, *baud, readTimeout)
+		}
+		device, err = reopen()
+		if err != nil {
+			return nil, fmt.Errorf("error opening device: %s", err)
+		}
+	}
+
+	d := &Device{DeviceSpec: spec, hub: NewHub()}
+	d.mc = NewMindControl(d.hub.broadcast, make(chan bool, 1), device, reopen, board, policy)
+	d.mc.replay = replay
+	d.mc.dataDir = dataDir
+	d.mc.notch = notch
+	d.mc.filterSpecs = defaultFilterSpecs(board.Channels, notch)
+	if device == nil {
+		err = d.mc.StartGenTest(DefaultGeneratorConfig(board))
+		if err != nil {
+			return nil, err
+		}
+	}
+	d.handle = NewHandle(d.mc)
+	d.routes()
+	return d, nil
+}
+
+//routes sets up the device's own routes, served at the root for the
+//first device and under /devices/{id} for every device
+func (d *Device) routes() {
+	d.mux = http.NewServeMux()
+	d.mux.HandleFunc("/ws", d.hub.wsPacketHandler)
+
+	d.mux.HandleFunc("/", d.handle.rootHandler)
+	d.mux.HandleFunc("/x/", d.handle.commandHandler)
+	d.mux.HandleFunc("/channels", d.handle.channelsHandler)
+	d.mux.HandleFunc("/filters", d.handle.filtersHandler)
+	d.mux.HandleFunc("/notch", d.handle.notchHandler)
+	d.mux.HandleFunc("/bands", d.handle.bandsHandler)
+	d.mux.HandleFunc("/fft/", d.handle.fftHandler)
+	d.mux.HandleFunc("/rate/", d.handle.rateHandler)
+	d.mux.HandleFunc("/config", d.handle.configHandler)
+	d.mux.HandleFunc("/impedance", d.handle.impedanceHandler)
+	d.mux.HandleFunc("/gentest", d.handle.genTestHandler)
+	d.mux.HandleFunc("/replay", d.handle.replayHandler)
+	d.mux.HandleFunc("/reset", d.handle.resetHandler)
+	d.mux.HandleFunc("/info", d.handle.infoHandler)
+	d.mux.HandleFunc("/command", d.handle.executeHandler)
+	d.mux.HandleFunc("/start", d.handle.startHandler)
+	d.mux.HandleFunc("/stop", d.handle.stopHandler)
+	d.mux.HandleFunc("/close", d.handle.closeHandler)
+	d.mux.HandleFunc("/save", d.handle.saveHandler)
+	d.mux.HandleFunc("/capture", d.handle.captureHandler)
+	d.mux.HandleFunc("/import", d.handle.importHandler)
+	d.mux.HandleFunc("/js/", d.handle.jsHandler)
+	d.mux.HandleFunc("/static/", d.handle.cssHandler)
+	d.mux.HandleFunc("/bootstrap/", d.handle.bootstrapHandler)
+	d.mux.HandleFunc("/js/libs/", d.handle.libsHandler)
+}
+
+//Start runs the device's hub and pipeline. A serial or WiFi board is
+//reset so the banner tells which board it really is.
+func (d *Device) Start() {
+	go d.hub.Run()
+	go d.mc.Start()
+	if d.mc.reopen != nil {
+		go func() {
+			_, err := d.mc.Identify()
+			if err != nil {
+				glog.Errorf("device %s: error identifying board: %s\n", d.ID, err)
+			}
+		}()
+	}
+}
+
+//Info reports the device's current state
+func (d *Device) Info() DeviceInfo {
+	d.mc.mu.Lock()
+	defer d.mc.mu.Unlock()
+	return DeviceInfo{
+		ID:               d.ID,
+		Location:         d.Location,
+		Board:            d.mc.board.Name,
+		Channels:         d.mc.board.Channels,
+		SamplesPerSecond: d.mc.board.SamplesPerSecond,
+		Connected:        d.mc.device.connected(),
+		Streaming:        d.mc.streaming,
+		Saving:           d.mc.saving,
+		Capturing:        d.mc.capturing,
+		GenTesting:       d.mc.genTesting,
+		Replaying:        d.mc.replay != nil,
+		Firmware:         d.mc.firmware,
+	}
+}
+
+//deviceSet serves the devices under /devices. A device leaves the set
+//when it is closed, shutdown is closed once the last one has left.
+type deviceSet struct {
+	mu       sync.Mutex
+	devices  map[string]*Device
+	all      []*Device
+	shutdown chan bool
+}
+
+func newDeviceSet() *deviceSet {
+	return &deviceSet{
+		devices:  make(map[string]*Device),
+		shutdown: make(chan bool),
+	}
+}
+
+//add starts d and serves it until it is closed
+func (ds *deviceSet) add(d *Device) {
+	ds.mu.Lock()
+	ds.devices[d.ID] = d
+	ds.all = append(ds.all, d)
+	ds.mu.Unlock()
+	d.Start()
+	go func() {
+		<-d.mc.shutdown
+		glog.Infof("Device %s closed\n", d.ID)
+		ds.mu.Lock()
+		defer ds.mu.Unlock()
+		delete(ds.devices, d.ID)
+		if len(ds.devices) == 0 {
+			close(ds.shutdown)
+		}
+	}()
+}
+
+//get returns the open device with id
+func (ds *deviceSet) get(id string) (*Device, bool) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	d, ok := ds.devices[id]
+	return d, ok
+}
+
+//root serves the routes of the device with id at the top level, the way
+//a single board is served, for as long as it is open
+func (ds *deviceSet) root(id string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, ok := ds.get(id)
+		if !ok {
+			http.Error(w, "Not found, no device "+id, 404)
+			return
+		}
+		d.mux.ServeHTTP(w, r)
+	})
+}
+
+//Close shuts the hubs of every device that was served
+func (ds *deviceSet) Close() {
+	for _, d := range ds.all {
+		d.hub.Close()
+	}
+}
+
+//ServeHTTP lists the devices on /devices and hands /devices/{id}/... to
+//the device's own routes
+func (ds *deviceSet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/devices"), "/")
+	if path == "" {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", 405)
+			return
+		}
+		ds.mu.Lock()
+		infos := make([]DeviceInfo, 0, len(ds.devices))
+		for _, d := range ds.devices {
+			infos = append(infos, d.Info())
+		}
+		ds.mu.Unlock()
+		sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(infos)
+		return
+	}
+	id := strings.SplitN(path, "/", 2)[0]
+	d, ok := ds.get(id)
+	if !ok {
+		http.Error(w, "Not found, no device "+id, 404)
+		return
+	}
+	http.StripPrefix("/devices/"+id, d.mux).ServeHTTP(w, r)
+}
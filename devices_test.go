@@ -0,0 +1,128 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type testdevicespecpair struct {
+	flag   string
+	result []DeviceSpec
+}
+
+var testsdevicespec = []testdevicespecpair{
+	{"a=/dev/ttyUSB0", []DeviceSpec{{"a", "/dev/ttyUSB0", false}}},
+	{"left=/dev/ttyUSB0?daisy=true,right=", []DeviceSpec{{"left", "/dev/ttyUSB0", true}, {"right", "", false}}},
+	{"r=replay:data/1.edf?daisy=0", []DeviceSpec{{"r", "replay:data/1.edf", false}}},
+	{"a=x,a=y", nil},
+	{"/dev/ttyUSB0", nil},
+	{"a/b=x", nil},
+	{"a=x?daisy=maybe", nil},
+}
+
+func TestParseDeviceSpecs(t *testing.T) {
+	for _, pair := range testsdevicespec {
+		v, err := ParseDeviceSpecs(pair.flag)
+		if pair.result == nil {
+			if err == nil {
+				t.Error("For", pair.flag, "expected", "error", "got", v)
+			}
+			continue
+		}
+		if err != nil || len(v) != len(pair.result) {
+			t.Error("For", pair.flag, "expected", pair.result, "got", v, err)
+			continue
+		}
+		for i := range v {
+			if v[i] != pair.result[i] {
+				t.Error("For", pair.flag, "expected", pair.result[i], "got", v[i])
+			}
+		}
+	}
+}
+
+func TestDeviceSet(t *testing.T) {
+	ds := newDeviceSet()
+	specs := []DeviceSpec{{ID: "left"}, {ID: "right", Daisy: true}}
+	for _, spec := range specs {
+		d, err := OpenDevice(spec, RecoverRepeat, "data/"+spec.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds.add(d)
+	}
+
+	w := httptest.NewRecorder()
+	ds.ServeHTTP(w, httptest.NewRequest("GET", "/devices", nil))
+	var infos []DeviceInfo
+	err := json.NewDecoder(w.Body).Decode(&infos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 || infos[0].ID != "left" || infos[1].Channels != 16 || !infos[1].GenTesting {
+		t.Error("For", "device list", "expected", specs, "got", infos)
+	}
+
+	w = httptest.NewRecorder()
+	ds.ServeHTTP(w, httptest.NewRequest("GET", "/devices/right/config", nil))
+	var config BoardConfig
+	err = json.NewDecoder(w.Body).Decode(&config)
+	if err != nil || len(config.Channels) != 16 {
+		t.Error("For", "right's config", "expected", 16, "channels", "got", len(config.Channels), err)
+	}
+
+	w = httptest.NewRecorder()
+	ds.ServeHTTP(w, httptest.NewRequest("GET", "/devices/middle/config", nil))
+	if w.Code != 404 {
+		t.Error("For", "unknown device", "expected", 404, "got", w.Code)
+	}
+
+	left, _ := ds.get("left")
+	root := ds.root("left")
+	w = httptest.NewRecorder()
+	root.ServeHTTP(w, httptest.NewRequest("GET", "/config", nil))
+	if w.Code != 200 {
+		t.Error("For", "the first device at the root", "expected", 200, "got", w.Code)
+	}
+	w = httptest.NewRecorder()
+	root.ServeHTTP(w, httptest.NewRequest("POST", "/close", nil))
+	for _, spec := range specs {
+		d, _ := ds.get(spec.ID)
+		if d != nil {
+			d.mc.Close()
+		}
+	}
+	select {
+	case <-ds.shutdown:
+	case <-time.After(time.Second):
+		t.Error("For", "closing every device", "expected", "shutdown", "got", "timeout")
+	}
+	//closed, the root is gone and a second close does nothing
+	w = httptest.NewRecorder()
+	root.ServeHTTP(w, httptest.NewRequest("POST", "/close", nil))
+	if w.Code != 404 {
+		t.Error("For", "the root of a closed device", "expected", 404, "got", w.Code)
+	}
+	left.mc.Close()
+	ds.Close()
+}
@@ -0,0 +1,150 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+//fourier is a forward discrete Fourier transform of a fixed size.
+//Making one may be costly, it is meant to be kept and reused. The FFT
+//backend is chosen at build time by newFourier, pure Go by default and
+//FFTW with the fftw build tag.
+type fourier interface {
+	//transform returns the transform of input, which is only valid
+	//until the next call
+	transform(input []float64) []complex128
+	free()
+}
+
+//goFFT is an iterative radix-2 FFT. Sizes that are not a power of two
+//are done with Bluestein's algorithm as a convolution of a power of two
+//length. Everything is allocated up front.
+type goFFT struct {
+	n       int
+	rev     []int
+	twiddle []complex128
+	buf     []complex128
+	//chirp and filter are only there for Bluestein's algorithm
+	chirp  []complex128
+	filter []complex128
+	out    []complex128
+}
+
+func newGoFFT(n int) *goFFT {
+	m := 1
+	for m < n {
+		m <<= 1
+	}
+	if m != n {
+		for m < 2*n-1 {
+			m <<= 1
+		}
+	}
+	f := &goFFT{
+		n:       n,
+		rev:     make([]int, m),
+		twiddle: make([]complex128, m/2),
+		buf:     make([]complex128, m),
+		out:     make([]complex128, n),
+	}
+	bits := uint(0)
+	for 1<<bits < m {
+		bits++
+	}
+	for i := range f.rev {
+		for b := uint(0); b < bits; b++ {
+			f.rev[i] |= (i >> b & 1) << (bits - 1 - b)
+		}
+	}
+	for k := range f.twiddle {
+		f.twiddle[k] = cmplx.Exp(complex(0, -2*math.Pi*float64(k)/float64(m)))
+	}
+	if m == n {
+		return f
+	}
+	//w[k] = exp(-i pi k^2 / n), with k^2 taken modulo 2n to keep the
+	//angle small
+	f.chirp = make([]complex128, n)
+	for k := range f.chirp {
+		kk := (k * k) % (2 * n)
+		f.chirp[k] = cmplx.Exp(complex(0, -math.Pi*float64(kk)/float64(n)))
+	}
+	f.filter = make([]complex128, m)
+	f.filter[0] = cmplx.Conj(f.chirp[0])
+	for k := 1; k < n; k++ {
+		f.filter[k] = cmplx.Conj(f.chirp[k])
+		f.filter[m-k] = f.filter[k]
+	}
+	f.fft(f.filter)
+	return f
+}
+
+//fft transforms a, whose length is a power of two, in place
+func (f *goFFT) fft(a []complex128) {
+	m := len(a)
+	for i, j := range f.rev {
+		if i < j {
+			a[i], a[j] = a[j], a[i]
+		}
+	}
+	for size := 2; size <= m; size <<= 1 {
+		half := size / 2
+		step := m / size
+		for start := 0; start < m; start += size {
+			for k := 0; k < half; k++ {
+				t := f.twiddle[k*step] * a[start+k+half]
+				a[start+k+half] = a[start+k] - t
+				a[start+k] += t
+			}
+		}
+	}
+}
+
+func (f *goFFT) transform(input []float64) []complex128 {
+	if f.chirp == nil {
+		for i, val := range input {
+			f.buf[i] = complex(val, 0)
+		}
+		f.fft(f.buf)
+		return f.buf
+	}
+	//X[k] = w[k] * sum_j (x[j] w[j]) conj(w[k-j]), a circular convolution
+	//done with two power of two transforms. The inverse transform is the
+	//forward one of the conjugate.
+	m := len(f.buf)
+	for i := range f.buf {
+		f.buf[i] = 0
+	}
+	for i, val := range input {
+		f.buf[i] = complex(val, 0) * f.chirp[i]
+	}
+	f.fft(f.buf)
+	for i := range f.buf {
+		f.buf[i] = cmplx.Conj(f.buf[i] * f.filter[i])
+	}
+	f.fft(f.buf)
+	for k := range f.out {
+		f.out[k] = cmplx.Conj(f.buf[k]) / complex(float64(m), 0) * f.chirp[k]
+	}
+	return f.out
+}
+
+func (f *goFFT) free() {}
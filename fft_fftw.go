@@ -0,0 +1,58 @@
+//go:build fftw
+// +build fftw
+
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"github.com/runningwild/go-fftw/fftw"
+)
+
+//newFourier makes the transforms of the spectral code with FFTW
+func newFourier(n int) fourier {
+	return newFFTWPlan(n)
+}
+
+//fftwPlan is an FFTW plan along with the array it transforms in place.
+//Planning is costly, so a plan is made once for a size and reused.
+type fftwPlan struct {
+	data *fftw.Array
+	plan *fftw.Plan
+}
+
+func newFFTWPlan(n int) *fftwPlan {
+	data := fftw.NewArray(n)
+	return &fftwPlan{
+		data: data,
+		plan: fftw.NewPlan(data, data, fftw.Forward, fftw.Estimate),
+	}
+}
+
+func (p *fftwPlan) transform(input []float64) []complex128 {
+	for idx, val := range input {
+		p.data.Set(idx, complex(val, 0.0))
+	}
+	p.plan.Execute()
+	return p.data.Elems
+}
+
+func (p *fftwPlan) free() {
+	p.plan.Destroy()
+}
@@ -0,0 +1,58 @@
+//go:build fftw
+// +build fftw
+
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+//TestBackendsAgree runs the same estimates through the pure Go FFT and
+//FFTW
+func TestBackendsAgree(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, size := range []int{250, 256, 1000} {
+		x := make([]float64, size)
+		for i := range x {
+			x[i] = 20*math.Sin(2*math.Pi*10*float64(i)/250) + 5*rng.NormFloat64()
+		}
+		for _, c := range []PSDConfig{
+			defaultPSDConfig,
+			{Window: "blackman", Segment: size / 4, Overlap: 0.75, Scale: scaleDB},
+		} {
+			e := newWelchEstimator(c, size)
+			fromFFTW := make([]float64, e.bins())
+			e.estimate(x, 250, fromFFTW)
+			e.free()
+			e.plan = newGoFFT(e.n)
+			fromGo := make([]float64, e.bins())
+			e.estimate(x, 250, fromGo)
+			for k := range fromGo {
+				if math.Abs(fromGo[k]-fromFFTW[k]) > 1e-9*math.Max(1, math.Abs(fromFFTW[k])) {
+					t.Error("For size", size, c, "bin", k, "expected", fromFFTW[k], "got", fromGo[k])
+					break
+				}
+			}
+		}
+	}
+}
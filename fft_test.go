@@ -0,0 +1,62 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"testing"
+)
+
+//naiveDFT is the transform straight from its definition
+func naiveDFT(input []float64) []complex128 {
+	n := len(input)
+	out := make([]complex128, n)
+	for k := range out {
+		for j, val := range input {
+			out[k] += complex(val, 0) * cmplx.Exp(complex(0, -2*math.Pi*float64(j*k%n)/float64(n)))
+		}
+	}
+	return out
+}
+
+func TestGoFFT(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, n := range []int{1, 2, 3, 8, 125, 250, 256, 500, 1000} {
+		f := newGoFFT(n)
+		for round := 0; round < 2; round++ {
+			x := make([]float64, n)
+			for i := range x {
+				x[i] = 100 * rng.NormFloat64()
+			}
+			want := naiveDFT(x)
+			got := f.transform(x)
+			if len(got) != n {
+				t.Fatal("For size", n, "expected", n, "values got", len(got))
+			}
+			for k := range want {
+				if cmplx.Abs(got[k]-want[k]) > 1e-8*float64(n)*100 {
+					t.Error("For size", n, "bin", k, "expected", want[k], "got", got[k])
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/kevinjos/gofidlib"
+)
+
+const (
+	//defaultFilterSpec is the band-pass every channel starts out with
+	defaultFilterSpec = "BpBe4/1-30"
+	//notchedFilterSpec replaces it when mains is notched out, only the
+	//drift goes and gamma above 30 Hz is kept
+	notchedFilterSpec = "HpBe4/1"
+)
+
+//ChannelFilter is the chain of gofidlib specs run on a channel, in order.
+//An empty chain leaves the channel unfiltered.
+type ChannelFilter struct {
+	Channel int
+	Specs   []string
+}
+
+//filterChain is a ChannelFilter designed for one sample rate
+type filterChain struct {
+	designs []*gofidlib.FilterDesign
+	filters []*gofidlib.Filter
+}
+
+//newFilterChain designs every spec for rate, nothing is left allocated
+//when one of them is invalid
+func newFilterChain(specs []string, rate int) (*filterChain, error) {
+	c := &filterChain{}
+	for _, spec := range specs {
+		design, err := gofidlib.NewFilterDesign(spec, float64(rate))
+		if err != nil {
+			c.free()
+			return nil, fmt.Errorf("bad filter %q at %d Hz: %s", spec, rate, err)
+		}
+		c.designs = append(c.designs, design)
+		c.filters = append(c.filters, gofidlib.NewFilter(design))
+	}
+	return c, nil
+}
+
+func (c *filterChain) run(val float64) float64 {
+	for _, f := range c.filters {
+		val = f.Run(val)
+	}
+	return val
+}
+
+func (c *filterChain) free() {
+	for i := range c.filters {
+		c.filters[i].Free()
+		c.designs[i].Free()
+	}
+}
+
+//newFilterChains designs a chain for every channel. A channel whose
+//chain cannot be designed at rate is logged and left unfiltered, so one
+//bad chain never holds up the others.
+func newFilterChains(specs [][]string, rate int) []*filterChain {
+	chains := make([]*filterChain, len(specs))
+	for ch, s := range specs {
+		c, err := newFilterChain(s, rate)
+		if err != nil {
+			glog.Errorf("channel %d left unfiltered: %s\n", ch+1, err)
+			c = &filterChain{}
+		}
+		chains[ch] = c
+	}
+	return chains
+}
+
+func freeFilterChains(chains []*filterChain) {
+	for _, c := range chains {
+		c.free()
+	}
+}
+
+//defaultFilterSpecs is the chain of every channel at start up with
+//notch in front of it
+func defaultFilterSpecs(channels int, notch MainsNotch) [][]string {
+	spec := defaultFilterSpec
+	if notch.Freq > 0 {
+		spec = notchedFilterSpec
+	}
+	specs := make([][]string, channels)
+	for i := range specs {
+		specs[i] = []string{spec}
+	}
+	return specs
+}
+
+//filterUpdate hands new chains for some channels, or a new mains notch
+//for all of them, to sendPackets
+type filterUpdate struct {
+	rate   int
+	chains map[int]*filterChain
+	notch  []*filterChain
+}
+
+//Filters returns the filter chain of every channel
+func (mc *MindControl) Filters() []ChannelFilter {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	filters := make([]ChannelFilter, len(mc.filterSpecs))
+	for ch, specs := range mc.filterSpecs {
+		filters[ch] = ChannelFilter{Channel: ch + 1, Specs: append([]string{}, specs...)}
+	}
+	return filters
+}
+
+//SetFilters designs the chains in filters and swaps them in for their
+//channels. The other channels keep their filters and filter state. Every
+//chain is designed before any is swapped in, so on error nothing changes.
+func (mc *MindControl) SetFilters(filters []ChannelFilter) ([]ChannelFilter, error) {
+	board := mc.currentBoard()
+	rate := board.SamplesPerSecond
+	update := filterUpdate{rate: rate, chains: make(map[int]*filterChain)}
+	for _, f := range filters {
+		var err error
+		switch {
+		case f.Channel < 1 || f.Channel > board.Channels:
+			err = fmt.Errorf("channel %d out of range 1-%d", f.Channel, board.Channels)
+		case update.chains[f.Channel-1] != nil:
+			err = fmt.Errorf("channel %d given twice", f.Channel)
+		default:
+			var c *filterChain
+			c, err = newFilterChain(f.Specs, rate)
+			if err != nil {
+				err = fmt.Errorf("channel %d: %s", f.Channel, err)
+				break
+			}
+			update.chains[f.Channel-1] = c
+		}
+		if err != nil {
+			for _, c := range update.chains {
+				c.free()
+			}
+			return nil, err
+		}
+	}
+	mc.mu.Lock()
+	for _, f := range filters {
+		if f.Channel <= len(mc.filterSpecs) {
+			mc.filterSpecs[f.Channel-1] = append([]string{}, f.Specs...)
+		}
+	}
+	mc.mu.Unlock()
+	mc.filterC <- update
+	return mc.Filters(), nil
+}
+
+//resizeFilters gives the chains of a board with another channel count,
+//channels that are new get the default chain. mc.mu is held.
+func (mc *MindControl) resizeFilters(channels int) {
+	specs := defaultFilterSpecs(channels, mc.notch)
+	copy(specs, mc.filterSpecs)
+	mc.filterSpecs = specs
+}
@@ -0,0 +1,100 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testfilterpair struct {
+	filters []ChannelFilter
+	ok      bool
+}
+
+var testsfilters = []testfilterpair{
+	{[]ChannelFilter{{2, []string{"HpBu2/1", "BsRe/100/60"}}, {8, nil}}, true},
+	{[]ChannelFilter{{1, []string{"LpBe4/40"}}}, true},
+	{[]ChannelFilter{{9, []string{"LpBe4/40"}}}, false},
+	{[]ChannelFilter{{0, nil}}, false},
+	{[]ChannelFilter{{3, nil}, {3, nil}}, false},
+	{[]ChannelFilter{{3, []string{"LpBe4/40"}}, {4, []string{"LpBe4/40", "Bad!"}}}, false},
+}
+
+func TestSetFilters(t *testing.T) {
+	for _, pair := range testsfilters {
+		mc := NewMindControl(nil, nil, nil, nil, Cyton, RecoverRepeat)
+		updates := make(chan filterUpdate, 1)
+		go func() { updates <- <-mc.filterC }()
+		res, err := mc.SetFilters(pair.filters)
+		if (err == nil) != pair.ok {
+			t.Error("For", pair.filters, "expected ok", pair.ok, "got", err)
+			continue
+		}
+		want := defaultFilterSpecs(8, MainsNotch{})
+		if pair.ok {
+			for _, f := range pair.filters {
+				want[f.Channel-1] = append([]string{}, f.Specs...)
+			}
+			u := <-updates
+			if len(u.chains) != len(pair.filters) || u.rate != Cyton.SamplesPerSecond {
+				t.Error("For", pair.filters, "expected chains for", len(pair.filters), "channels got", len(u.chains))
+			}
+			for _, f := range pair.filters {
+				if c := u.chains[f.Channel-1]; c == nil || len(c.filters) != len(f.Specs) {
+					t.Error("For", pair.filters, "expected", len(f.Specs), "filters on channel", f.Channel, "got", c)
+				}
+			}
+			for ch, specs := range want {
+				if !reflect.DeepEqual(res[ch], ChannelFilter{ch + 1, specs}) {
+					t.Error("For", pair.filters, "expected", specs, "on channel", ch+1, "got", res[ch])
+				}
+			}
+		}
+		if !reflect.DeepEqual(mc.filterSpecs, want) {
+			t.Error("For", pair.filters, "expected", want, "got", mc.filterSpecs)
+		}
+	}
+}
+
+func TestResizeFilters(t *testing.T) {
+	mc := NewMindControl(nil, nil, nil, nil, Cyton, RecoverRepeat)
+	mc.filterSpecs[0] = []string{"HpBu2/1"}
+	mc.resizeFilters(16)
+	if len(mc.filterSpecs) != 16 || mc.filterSpecs[0][0] != "HpBu2/1" || mc.filterSpecs[15][0] != defaultFilterSpec {
+		t.Error("For 8 to 16 channels expected the first chain kept and defaults added got", mc.filterSpecs)
+	}
+}
+
+func TestDefaultFilterSpecs(t *testing.T) {
+	for notch, spec := range map[MainsNotch]string{
+		{}: defaultFilterSpec, {60, 0}: notchedFilterSpec, {50, 2}: notchedFilterSpec,
+	} {
+		specs := defaultFilterSpecs(4, notch)
+		if len(specs) != 4 || !reflect.DeepEqual(specs[3], []string{spec}) {
+			t.Error("For", notch, "expected", spec, "on every channel got", specs)
+		}
+	}
+	mc := NewMindControl(nil, nil, nil, nil, Cyton, RecoverRepeat)
+	mc.notch = MainsNotch{50, 0}
+	mc.resizeFilters(16)
+	if mc.filterSpecs[0][0] != defaultFilterSpec || mc.filterSpecs[15][0] != notchedFilterSpec {
+		t.Error("For 8 to 16 channels with a notch expected the high-pass added got", mc.filterSpecs)
+	}
+}
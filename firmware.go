@@ -0,0 +1,167 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kevinjos/openbci-driver"
+)
+
+//firmware is the part of the board's command handling the simulated
+//devices share: reset, channel power, channel settings, lead-off, the
+//sample rate and the register query. Devices handle start and stop
+//themselves and feed it every other byte written while idle is true.
+type firmware struct {
+	settings []ChannelSettings
+	leadOff  []bool
+	daisy    bool
+	cmd      []byte
+}
+
+func newFirmware(board Board) firmware {
+	f := firmware{daisy: board.Daisy}
+	f.settings = make([]ChannelSettings, board.Channels)
+	f.reset()
+	return f
+}
+
+//reset puts the channels back to the firmware defaults
+func (f *firmware) reset() {
+	f.leadOff = make([]bool, len(f.settings))
+	copy(f.settings, defaultChannelSettings(len(f.settings)))
+}
+
+//idle is true unless f is in the middle of a multi byte command
+func (f *firmware) idle() bool {
+	return len(f.cmd) == 0
+}
+
+//write takes one byte of a command. It returns the text the board
+//answers with and, after a sample rate command, the new rate in Hz.
+func (f *firmware) write(b byte) (string, int) {
+	if !f.idle() {
+		f.cmd = append(f.cmd, b)
+		return f.command()
+	}
+	switch {
+	case b == openbci.Command["reset"]:
+		f.reset()
+		return firmwareBanner(f.daisy), 0
+	case b == queryRegisters:
+		return formatRegisters(f.settings) + responseEnd, 0
+	case b == '~' || b == 'x' || b == 'z':
+		f.cmd = []byte{b}
+	case strings.IndexByte(channelsOn[:len(f.settings)], b) >= 0:
+		f.settings[strings.IndexByte(channelsOn, b)].PowerDown = false
+	case strings.IndexByte(channelsOff[:len(f.settings)], b) >= 0:
+		f.settings[strings.IndexByte(channelsOff, b)].PowerDown = true
+	}
+	return "", 0
+}
+
+//command applies a multi byte command once all of it has been written
+func (f *firmware) command() (string, int) {
+	cmd := f.cmd
+	var hz int
+	switch {
+	case cmd[0] == '~' && len(cmd) == 2:
+		for rate, c := range sampleRateCommands {
+			if c == cmd[1] {
+				hz = rate
+			}
+		}
+	case cmd[0] == 'x' && len(cmd) == 9:
+		ch := strings.IndexByte(channelIDs, cmd[1])
+		if ch < 0 || ch >= len(f.settings) || cmd[8] != 'X' || cmd[3]-'0' >= byte(len(gainCodes)) ||
+			cmd[4]-'0' >= byte(len(inputTypes)) {
+			break
+		}
+		f.settings[ch] = ChannelSettings{
+			Channel:   ch + 1,
+			PowerDown: cmd[2] == '1',
+			Gain:      gainCodes[cmd[3]-'0'],
+			InputType: inputTypes[cmd[4]-'0'],
+			Bias:      cmd[5] == '1',
+			SRB2:      cmd[6] == '1',
+			SRB1:      cmd[7] == '1',
+		}
+	case cmd[0] == 'z' && len(cmd) == 5:
+		ch := strings.IndexByte(channelIDs, cmd[1])
+		if ch >= 0 && ch < len(f.leadOff) && cmd[4] == 'Z' {
+			f.leadOff[ch] = cmd[2] == '1'
+		}
+	default:
+		return "", 0
+	}
+	f.cmd = nil
+	return "", hz
+}
+
+//formatRegisters is the answer to the ? command for settings, holding
+//the registers parseRegisters reads
+func formatRegisters(settings []ChannelSettings) string {
+	var dump string
+	for chip := 0; chip*8 < len(settings); chip++ {
+		name := "Board"
+		if chip > 0 {
+			name = "Daisy"
+		}
+		dump += name + " ADS Registers\n"
+		var bias, misc1 byte
+		for i, s := range settings[chip*8 : chip*8+8] {
+			var chset byte
+			if s.PowerDown {
+				chset |= 0x80
+			}
+			for code, gain := range gainCodes {
+				if gain == s.Gain {
+					chset |= byte(code) << 4
+				}
+			}
+			if s.SRB2 {
+				chset |= 0x08
+			}
+			for code, input := range inputTypes {
+				if input == s.InputType {
+					chset |= byte(code)
+				}
+			}
+			if s.Bias {
+				bias |= 1 << uint(i)
+			}
+			if s.SRB1 {
+				misc1 = 0x20
+			}
+			dump += fmt.Sprintf("CH%dSET, %02X, %02X\n", i+1, 5+i, chset)
+		}
+		dump += fmt.Sprintf("BIAS_SENSP, 0D, %02X\nBIAS_SENSN, 0E, %02X\nMISC1, 15, %02X\n", bias, bias, misc1)
+	}
+	return dump
+}
+
+//firmwareBanner is the reset response of a v3 firmware board
+func firmwareBanner(daisy bool) string {
+	banner := "OpenBCI V3 8-16 channel\nOn Board ADS1299 Device ID: 0x3E\n"
+	if daisy {
+		banner += "On Daisy ADS1299 Device ID: 0x3E\n"
+	}
+	return banner + "LIS3DH Device ID: 0x33\nFirmware: v3.1.2\n" + responseEnd
+}
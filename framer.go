@@ -0,0 +1,112 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/kevinjos/eeg-web-server/int24"
+	"github.com/kevinjos/openbci-driver"
+)
+
+//frameSize is the length of one frame from header to stop byte
+const frameSize = 33
+
+//framer cuts the byte stream of a device into frames. It reads in large
+//chunks and accepts a frame wherever a header byte is followed by a stop
+//byte frameSize-1 bytes later. Bytes that do not fit are discarded, so
+//after corruption it resyncs on the next good frame in the buffer.
+type framer struct {
+	r          io.Reader
+	buf        []byte
+	start, end int
+	frame      [frameSize]byte
+	Frames     uint64
+	Discarded  uint64
+}
+
+func newFramer(r io.Reader, size int) *framer {
+	if size < frameSize {
+		size = frameSize
+	}
+	return &framer{
+		r:   r,
+		buf: make([]byte, size),
+	}
+}
+
+//next returns the next frame along with the number of bytes discarded
+//in front of it. The frame is only valid until the following call.
+//Read errors are returned once the buffered frames are used up.
+func (f *framer) next() (*[frameSize]byte, int, error) {
+	header := openbci.Command["header"]
+	var discarded int
+	for {
+		for f.end-f.start >= frameSize {
+			b := f.buf[f.start:f.end]
+			if b[0] == header && isStopByte(b[frameSize-1]) {
+				copy(f.frame[:], b)
+				f.start += frameSize
+				f.Frames++
+				return &f.frame, discarded, nil
+			}
+			skip := bytes.IndexByte(b[1:], header) + 1
+			if skip == 0 {
+				skip = len(b)
+			}
+			f.start += skip
+			f.Discarded += uint64(skip)
+			discarded += skip
+		}
+		f.end = copy(f.buf, f.buf[f.start:f.end])
+		f.start = 0
+		n, err := f.r.Read(f.buf[f.end:])
+		f.end += n
+		if n == 0 && err != nil {
+			return nil, discarded, err
+		}
+	}
+}
+
+//marshalFrame is the inverse of encodePacket for a single frame: eight
+//channels of counts, six aux bytes and the stop byte that tells their format
+func marshalFrame(seq byte, counts []int32, aux [6]byte, stop byte) [frameSize]byte {
+	var frame [frameSize]byte
+	frame[0] = openbci.Command["header"]
+	frame[1] = seq
+	for i, c := range counts {
+		copy(frame[2+3*i:5+3*i], int24.MarshalSBE(c))
+	}
+	copy(frame[26:32], aux[:])
+	frame[32] = stop
+	return frame
+}
+
+//sampleFrames lays one sample out in the frames the board sends for it.
+//With 16 channels the Cyton's channels go first, on the odd sequence
+//number, and the daisy's follow, so seq has to be even on the way in.
+func sampleFrames(seq *byte, counts []int32) [][frameSize]byte {
+	var frames [][frameSize]byte
+	for first := 0; first+8 <= len(counts); first += 8 {
+		*seq++
+		frames = append(frames, marshalFrame(*seq, counts[first:first+8], [6]byte{}, openbci.Command["footer"]))
+	}
+	return frames
+}
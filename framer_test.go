@@ -0,0 +1,174 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func testFrame(seq byte) []byte {
+	frame := make([]byte, frameSize)
+	frame[0] = 0xa0
+	frame[1] = seq
+	for i := 2; i < frameSize-1; i++ {
+		frame[i] = seq + byte(i)
+	}
+	frame[frameSize-1] = 0xc0
+	return frame
+}
+
+func testStream(n int) []byte {
+	var stream []byte
+	for i := 0; i < n; i++ {
+		stream = append(stream, testFrame(byte(i))...)
+	}
+	return stream
+}
+
+type testframerpair struct {
+	name      string
+	stream    []byte
+	seqs      []byte
+	discarded uint64
+}
+
+var testsframer = []testframerpair{
+	{"clean", testStream(3), []byte{0, 1, 2}, 0},
+	{"garbage prefix", append([]byte{1, 0xa0, 3}, testStream(2)...), []byte{0, 1}, 3},
+	{"truncated frame", append(append(testFrame(7)[:20], testFrame(8)...), testFrame(9)...), []byte{8, 9}, 20},
+	{"bad stop byte", append(append(testFrame(7)[:32], 0xc7), testFrame(8)...), []byte{8}, 33},
+	{"partial tail", append(testStream(1), testFrame(1)[:10]...), []byte{0}, 0},
+}
+
+func TestFramer(t *testing.T) {
+	for _, pair := range testsframer {
+		for _, r := range []io.Reader{bytes.NewReader(pair.stream), iotest.OneByteReader(bytes.NewReader(pair.stream))} {
+			f := newFramer(r, 64)
+			var seqs []byte
+			for {
+				frame, _, err := f.next()
+				if err != nil {
+					break
+				}
+				if !bytes.Equal(frame[:], testFrame(frame[1])) {
+					t.Error("For", pair.name, "got corrupted frame", frame)
+				}
+				seqs = append(seqs, frame[1])
+			}
+			if !bytes.Equal(seqs, pair.seqs) || f.Discarded != pair.discarded {
+				t.Error(
+					"For", pair.name,
+					"expected", pair.seqs, pair.discarded,
+					"got", seqs, f.Discarded,
+				)
+			}
+		}
+	}
+}
+
+//readFramesBytewise is the one byte per read state machine DecodeStream
+//used before the framer, kept as the benchmark baseline
+func readFramesBytewise(r io.Reader) int {
+	var (
+		readstate uint8
+		frame     [frameSize]byte
+		frames    int
+	)
+	buf := make([]byte, 1)
+	for {
+		if _, err := r.Read(buf); err != nil {
+			return frames
+		}
+		b := buf[0]
+		switch readstate {
+		case 0:
+			if isStopByte(b) {
+				readstate++
+			}
+		case 1:
+			if b == 0xa0 {
+				frame[0] = b
+				readstate++
+			} else {
+				readstate = 0
+			}
+		case 2:
+			frame[1] = b
+			for j := 2; j < 32; j++ {
+				if _, err := r.Read(buf); err != nil {
+					return frames
+				}
+				frame[j] = buf[0]
+			}
+			readstate = 3
+		case 3:
+			if isStopByte(b) {
+				frame[32] = b
+				frames++
+				readstate = 1
+			} else {
+				readstate = 0
+			}
+		}
+	}
+}
+
+//countingReader counts the Read calls, each one a syscall on a serial device
+type countingReader struct {
+	r     io.Reader
+	reads int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	c.reads++
+	return c.r.Read(p)
+}
+
+func BenchmarkDecodeBytewise(b *testing.B) {
+	stream := testStream(1000)
+	b.SetBytes(int64(len(stream)))
+	var reads int
+	for i := 0; i < b.N; i++ {
+		r := &countingReader{r: bytes.NewReader(stream)}
+		readFramesBytewise(r)
+		reads += r.reads
+	}
+	b.ReportMetric(float64(reads)/float64(b.N), "reads/op")
+}
+
+func BenchmarkDecodeFramer(b *testing.B) {
+	stream := testStream(1000)
+	b.SetBytes(int64(len(stream)))
+	f := newFramer(nil, 4096)
+	var reads int
+	for i := 0; i < b.N; i++ {
+		r := &countingReader{r: bytes.NewReader(stream)}
+		f.r, f.start, f.end = r, 0, 0
+		for {
+			if _, _, err := f.next(); err != nil {
+				break
+			}
+		}
+		reads += r.reads
+	}
+	b.ReportMetric(float64(reads)/float64(b.N), "reads/op")
+}
@@ -0,0 +1,265 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/kevinjos/openbci-driver"
+)
+
+//pinkNorm brings the output of the pink noise filter to about unit RMS
+const pinkNorm = 1.0 / 3
+
+//Sine is one sinusoid on a channel, amplitude in microvolts
+type Sine struct {
+	Freq      float64
+	Amplitude float64
+}
+
+//ChannelSignal is what the generator puts on one channel. Amplitudes are
+//in microvolts, PinkNoise is roughly the RMS of the noise.
+type ChannelSignal struct {
+	Sines     []Sine
+	PinkNoise float64
+	LineNoise float64
+	Blink     float64
+	Impedance float64
+}
+
+//GeneratorConfig sets up the synthetic board. DropRate and CorruptRate
+//are the fractions of frames dropped and damaged on the way out.
+type GeneratorConfig struct {
+	SamplesPerSecond int
+	Daisy            bool
+	LineFreq         float64
+	BlinksPerMinute  float64
+	DropRate         float64
+	CorruptRate      float64
+	Seed             int64
+	Channels         []ChannelSignal
+}
+
+//DefaultGeneratorConfig is alpha and some beta on every channel on top
+//of pink noise and 60 Hz mains, with blinks on the two frontal channels
+func DefaultGeneratorConfig(board Board) GeneratorConfig {
+	config := GeneratorConfig{
+		SamplesPerSecond: board.SamplesPerSecond,
+		Daisy:            board.Daisy,
+		LineFreq:         60,
+		BlinksPerMinute:  12,
+		Seed:             1,
+		Channels:         make([]ChannelSignal, board.Channels),
+	}
+	for i := range config.Channels {
+		config.Channels[i] = ChannelSignal{
+			Sines:     []Sine{{10, 20}, {20, 5}},
+			PinkNoise: 5,
+			LineNoise: 10,
+			Impedance: 5,
+		}
+	}
+	config.Channels[0].Blink = 150
+	config.Channels[1].Blink = 150
+	return config
+}
+
+//pinkNoise is Paul Kellet's economy filter turning white into pink noise
+type pinkNoise struct {
+	b0, b1, b2 float64
+}
+
+func (p *pinkNoise) next(white float64) float64 {
+	p.b0 = 0.99765*p.b0 + white*0.0990460
+	p.b1 = 0.96300*p.b1 + white*0.2965164
+	p.b2 = 0.57000*p.b2 + white*1.0526913
+	return (p.b0 + p.b1 + p.b2 + white*0.1848) * pinkNorm
+}
+
+//SignalGenerator is a synthetic board behind io.ReadWriteCloser. It
+//streams valid frames in real time once it is sent the start command and
+//understands enough of the other commands to stand in for the hardware:
+//stop, reset, sample rate, channel settings, lead-off and the register
+//query.
+type SignalGenerator struct {
+	pacedStream
+	firmware
+	config    GeneratorConfig
+	pink      []pinkNoise
+	rng       *rand.Rand
+	n         int64
+	seq       byte
+	nextBlink float64
+}
+
+//NewSignalGenerator returns a generator for config, it stops when quit
+//is closed
+func NewSignalGenerator(config GeneratorConfig, quit chan bool) *SignalGenerator {
+	g := &SignalGenerator{
+		pacedStream: newPacedStream(float64(config.SamplesPerSecond), quit),
+		firmware:    newFirmware(Board{Channels: len(config.Channels), Daisy: config.Daisy}),
+		config:      config,
+		pink:        make([]pinkNoise, len(config.Channels)),
+		rng:         rand.New(rand.NewSource(config.Seed)),
+	}
+	return g
+}
+
+//Run produces frames until quit is closed
+func (g *SignalGenerator) Run() {
+	g.run(g.frames)
+}
+
+//frames returns the frames of the next sample, two with the daisy
+func (g *SignalGenerator) frames() []byte {
+	uv := g.sample()
+	counts := make([]int32, len(uv))
+	for ch, v := range uv {
+		counts[ch] = countsFromMicroVolts(v, float64(g.settings[ch].Gain))
+	}
+	var out []byte
+	for _, frame := range sampleFrames(&g.seq, counts) {
+		if g.rng.Float64() < g.config.DropRate {
+			continue
+		}
+		if g.rng.Float64() < g.config.CorruptRate {
+			frame[g.rng.Intn(frameSize)] = byte(g.rng.Intn(256))
+		}
+		out = append(out, frame[:]...)
+	}
+	return out
+}
+
+//sample returns the microvolts on every channel at the next sample
+func (g *SignalGenerator) sample() []float64 {
+	t := float64(g.n) / float64(g.config.SamplesPerSecond)
+	g.n++
+	if g.config.BlinksPerMinute > 0 && t > g.nextBlink+blinkLength {
+		g.nextBlink = t + 60/g.config.BlinksPerMinute*(0.5+g.rng.Float64())
+	}
+	uv := make([]float64, len(g.config.Channels))
+	for ch, sig := range g.config.Channels {
+		white := g.rng.NormFloat64()
+		if g.settings[ch].PowerDown || g.settings[ch].InputType == "shorted" {
+			continue
+		}
+		var v float64
+		for _, s := range sig.Sines {
+			v += s.Amplitude * math.Sin(2*math.Pi*s.Freq*t)
+		}
+		v += sig.PinkNoise * g.pink[ch].next(white)
+		v += sig.LineNoise * math.Sin(2*math.Pi*g.config.LineFreq*t)
+		v += sig.Blink * blinkShape(t-g.nextBlink)
+		if g.leadOff[ch] {
+			amp := (sig.Impedance*1000 + seriesResistor) * leadOffCurrent * 1e6
+			v += amp * math.Sin(2*math.Pi*leadOffFreq*t)
+		}
+		uv[ch] = v
+	}
+	return uv
+}
+
+//blinkLength is how long, in seconds, a blink artifact lasts
+const blinkLength = 0.3
+
+//blinkShape is a unit bump over the blinkLength seconds after dt = 0
+func blinkShape(dt float64) float64 {
+	if dt < 0 || dt > blinkLength {
+		return 0
+	}
+	x := (dt - blinkLength/2) / (blinkLength / 6)
+	return math.Exp(-x * x / 2)
+}
+
+//Write takes commands the way the firmware does, one byte at a time
+func (g *SignalGenerator) Write(p []byte) (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, b := range p {
+		switch {
+		case g.idle() && b == openbci.Command["start"]:
+			g.streaming = true
+			g.restart()
+		case g.idle() && b == openbci.Command["stop"]:
+			g.streaming = false
+		default:
+			text, hz := g.write(b)
+			g.text = append(g.text, text...)
+			if hz > 0 {
+				g.config.SamplesPerSecond = hz
+				if g.config.Daisy {
+					g.config.SamplesPerSecond /= 2
+				}
+				g.rate = float64(g.config.SamplesPerSecond)
+				g.restart()
+			}
+		}
+	}
+	return len(p), nil
+}
+
+func (g *SignalGenerator) Close() error {
+	return nil
+}
+
+var errNoDevice = errors.New("no device to switch back to")
+
+//StartGenTest swaps the device for a signal generator, the decoder and
+//the websocket sessions carry on as they are
+func (mc *MindControl) StartGenTest(config GeneratorConfig) error {
+	if mc.genTesting {
+		return errors.New("generator already running")
+	}
+	board := mc.currentBoard()
+	if len(config.Channels) != board.Channels || config.Daisy != board.Daisy {
+		return fmt.Errorf("generator needs %d channels with daisy %t", board.Channels, board.Daisy)
+	}
+	if config.SamplesPerSecond <= 0 {
+		return errors.New("generator needs a positive sample rate")
+	}
+	g := NewSignalGenerator(config, mc.quitGenTest)
+	if mc.isStreaming() {
+		g.Write([]byte{openbci.Command["start"]})
+	}
+	go g.Run()
+	mc.generator = g
+	mc.realDevice = mc.device.replace(g)
+	mc.genTesting = true
+	return nil
+}
+
+//StopGenTest puts the real device back in place of the generator
+func (mc *MindControl) StopGenTest() error {
+	if !mc.genTesting {
+		return errors.New("generator not running")
+	}
+	if mc.realDevice == nil {
+		return errNoDevice
+	}
+	mc.device.replace(mc.realDevice)
+	close(mc.quitGenTest)
+	mc.quitGenTest = make(chan bool)
+	mc.generator = nil
+	mc.realDevice = nil
+	mc.genTesting = false
+	return nil
+}
@@ -0,0 +1,134 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func testGeneratorConfig(board Board) GeneratorConfig {
+	config := GeneratorConfig{
+		SamplesPerSecond: board.SamplesPerSecond,
+		Daisy:            board.Daisy,
+		Channels:         make([]ChannelSignal, board.Channels),
+	}
+	for i := range config.Channels {
+		config.Channels[i].Sines = []Sine{{Freq: 10, Amplitude: float64(10 * (i + 1))}}
+	}
+	return config
+}
+
+//generate decodes n samples' worth of generator output
+func generate(g *SignalGenerator, n int) []*Packet {
+	var stream []byte
+	for i := 0; i < n; i++ {
+		stream = append(stream, g.frames()...)
+	}
+	f := newFramer(bytes.NewReader(stream), readBufferSize)
+	var packets []*Packet
+	for {
+		frame, _, err := f.next()
+		if err != nil {
+			return packets
+		}
+		gain := make([]float64, 16)
+		for i := range gain {
+			gain[i] = 24
+		}
+		if !g.config.Daisy {
+			packets = append(packets, encodePacket(frame, nil, 100, gain, true))
+			continue
+		}
+		cyton := *frame
+		frame, _, err = f.next()
+		if err != nil {
+			return packets
+		}
+		packets = append(packets, encodePacket(&cyton, frame, 100, gain, true))
+	}
+}
+
+func TestSignalGenerator(t *testing.T) {
+	for _, board := range []Board{Cyton, CytonDaisy} {
+		g := NewSignalGenerator(testGeneratorConfig(board), make(chan bool))
+		packets := generate(g, board.SamplesPerSecond)
+		if len(packets) != board.SamplesPerSecond {
+			t.Error("For", board.Name, "expected", board.SamplesPerSecond, "packets", "got", len(packets))
+			continue
+		}
+		for ch := 0; ch < board.Channels; ch++ {
+			x := make([]float64, len(packets))
+			for i, p := range packets {
+				x[i] = p.Microvolts[ch]
+			}
+			amp := toneAmplitude(x, 10, float64(board.SamplesPerSecond))
+			if math.Abs(amp-float64(10*(ch+1))) > 0.1 {
+				t.Error("For", board.Name, chanName(ch), "expected", 10*(ch+1), "got", amp)
+			}
+		}
+	}
+}
+
+func TestSignalGeneratorDrops(t *testing.T) {
+	config := testGeneratorConfig(Cyton)
+	config.DropRate = 0.25
+	g := NewSignalGenerator(config, make(chan bool))
+	packets := generate(g, 1000)
+	if len(packets) < 700 || len(packets) > 800 {
+		t.Error("For", "drop rate 0.25", "expected", 750, "got", len(packets))
+	}
+	var gaps int
+	for i := 1; i < len(packets); i++ {
+		gaps += int(difference(packets[i].seqNum, packets[i-1].seqNum)) - 1
+	}
+	if gaps+len(packets) != 1000-int(packets[0].seqNum)+1 {
+		t.Error("For", "sequence gaps", "expected", 1000-int(packets[0].seqNum)+1-len(packets), "got", gaps)
+	}
+}
+
+func TestSignalGeneratorCommands(t *testing.T) {
+	g := NewSignalGenerator(testGeneratorConfig(CytonDaisy), make(chan bool))
+	settings := ChannelSettings{Channel: 11, PowerDown: false, Gain: 8, InputType: "shorted", Bias: false, SRB2: true}
+	g.Write([]byte(settings.command() + "2?"))
+	dump, err := readResponse(g, registerTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := parseRegisters(dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Channels) != 16 {
+		t.Fatal("For", "channels", "expected", 16, "got", len(config.Channels))
+	}
+	if config.Channels[10] != settings {
+		t.Error("For", "channel 11", "expected", settings, "got", config.Channels[10])
+	}
+	if !config.Channels[1].PowerDown {
+		t.Error("For", "channel 2", "expected", "power down", "got", config.Channels[1])
+	}
+	packets := generate(g, 10)
+	for _, p := range packets {
+		if p.Microvolts[1] != 0 || p.Microvolts[10] != 0 {
+			t.Error("For", "channels 2 and 11", "expected", 0, "got", p.Microvolts[1], p.Microvolts[10])
+		}
+	}
+}
@@ -19,14 +19,17 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"html/template"
+	"io/ioutil"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang/glog"
-	"github.com/kevinjos/openbci-driver"
 )
 
 type Handle struct {
@@ -41,28 +44,30 @@ func NewHandle(mindcontrol *MindControl) *Handle {
 
 func (handle *Handle) parseCommand(path string) string {
 	var command string
-	channelOn := map[string]string{"1": "!", "2": "@", "3": "#", "4": "$", "5": "%", "6": "^", "7": "&", "8": "*"}
 	gainMap := map[string]float64{"0": 1.0, "1": 2.0, "2": 4.0, "3": 6.0, "4": 8.0, "5": 12.0, "6": 24.0}
 	p := strings.Split(path, "/")
 	channel := p[2]
+	handle.mc.mu.Lock()
+	defer handle.mc.mu.Unlock()
+	ci, err := strconv.Atoi(channel)
+	if err != nil || ci < 0 || ci > handle.mc.board.Channels {
+		return ""
+	}
 	switch {
 	case len(p) < 4:
 		command = ""
-	case p[3] == "true":
-		command = channelOn[channel]
-	case p[3] == "false":
-		command = channel
-	case channel == "0": //send command to all channels
-		c := make([]string, 8)
-		for i := 0; i < 8; i++ {
-			c[i] = p[3][0:1] + strconv.Itoa(i+1) + p[3][2:]
+	case p[3] == "true" && ci > 0:
+		command = channelsOn[ci-1 : ci]
+	case p[3] == "false" && ci > 0:
+		command = channelsOff[ci-1 : ci]
+	case ci == 0: //send command to all channels
+		for i := 0; i < handle.mc.board.Channels; i++ {
+			command += p[3][0:1] + channelIDs[i:i+1] + p[3][2:]
 			handle.mc.gain[i] = gainMap[p[3][3:4]]
 		}
-		command = c[0] + c[1] + c[2] + c[3] + c[4] + c[5] + c[6] + c[7]
 	case p[3][0:1] == "x":
-		ci := channel[0] - 49
-		handle.mc.gain[ci] = gainMap[p[3][3:4]]
-		command = p[3]
+		handle.mc.gain[ci-1] = gainMap[p[3][3:4]]
+		command = p[3][0:1] + channelIDs[ci-1:ci] + p[3][2:]
 	}
 	return command
 }
@@ -133,26 +138,124 @@ func (handle *Handle) commandHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	command := handle.parseCommand(r.URL.Path)
 	lenCommand := len(command)
-	if lenCommand > 72 {
+	if lenCommand > 9*handle.mc.currentBoard().Channels {
+		http.Error(w, "Bad Request, command too long", 400)
+		return
+	}
+
+	handle.mc.writeCommand(command)
+}
+
+func (handle *Handle) channelsHandler(w http.ResponseWriter, r *http.Request) {
+	var settings []ChannelSettings
+	switch r.Method {
+	case "GET":
+		settings = handle.mc.ChannelSettings()
+	case "POST":
+		err := json.NewDecoder(r.Body).Decode(&settings)
+		if err != nil {
+			http.Error(w, "Bad Request, expected a JSON list of channel settings: "+err.Error(), 400)
+			return
+		}
+		err = handle.mc.validateChannelSettings(settings)
+		if err != nil {
+			http.Error(w, "Bad Request, "+err.Error(), 400)
+			return
+		}
+		settings, err = handle.mc.ApplyChannelSettings(settings)
+		if err == errDecoderPaused {
+			http.Error(w, err.Error(), 503)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	default:
 		http.Error(w, "Method not allowed", 405)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
 
-	for i := 0; i < lenCommand; i++ {
-		if lenCommand < 9 {
-			handle.mc.SerialDevice.Write([]byte{command[i]})
-		} else if i%9 == 0 && i+9 < lenCommand {
-			handle.staggerWriter(command[i : i+9])
-		} else if i%9 == 0 {
-			handle.staggerWriter(command[i:])
+func (handle *Handle) filtersHandler(w http.ResponseWriter, r *http.Request) {
+	var filters []ChannelFilter
+	switch r.Method {
+	case "GET":
+		filters = handle.mc.Filters()
+	case "POST":
+		err := json.NewDecoder(r.Body).Decode(&filters)
+		if err != nil {
+			http.Error(w, "Bad Request, expected a JSON list of channel filters: "+err.Error(), 400)
+			return
+		}
+		filters, err = handle.mc.SetFilters(filters)
+		if err != nil {
+			http.Error(w, "Bad Request, "+err.Error(), 400)
+			return
 		}
+	default:
+		http.Error(w, "Method not allowed", 405)
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(filters)
 }
 
-func (handle *Handle) staggerWriter(c string) {
-	handle.mc.SerialDevice.Write([]byte{c[0]})
-	handle.mc.SerialDevice.Write([]byte{c[1], c[2], c[3], c[4], c[5], c[6], c[7]})
-	handle.mc.SerialDevice.Write([]byte{c[8]})
+func (handle *Handle) notchHandler(w http.ResponseWriter, r *http.Request) {
+	handle.mc.mu.Lock()
+	notch := handle.mc.notch
+	handle.mc.mu.Unlock()
+	switch r.Method {
+	case "GET":
+	case "POST":
+		var n MainsNotch
+		err := json.NewDecoder(r.Body).Decode(&n)
+		if err != nil {
+			http.Error(w, "Bad Request, expected a JSON mains filter: "+err.Error(), 400)
+			return
+		}
+		notch, err = handle.mc.SetNotch(n)
+		if err == errSavingNotch {
+			http.Error(w, err.Error(), 409)
+			return
+		} else if err != nil {
+			http.Error(w, "Bad Request, "+err.Error(), 400)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notch)
+}
+
+func (handle *Handle) bandsHandler(w http.ResponseWriter, r *http.Request) {
+	bands := handle.mc.bands
+	switch r.Method {
+	case "GET":
+	case "POST":
+		var c BandConfig
+		err := json.NewDecoder(r.Body).Decode(&c)
+		if err != nil {
+			http.Error(w, "Bad Request, expected a JSON band configuration: "+err.Error(), 400)
+			return
+		}
+		bands, err = handle.mc.SetBands(c)
+		if err == errSavingBands {
+			http.Error(w, err.Error(), 409)
+			return
+		} else if err != nil {
+			http.Error(w, "Bad Request, "+err.Error(), 400)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bands)
 }
 
 func (handle *Handle) closeHandler(w http.ResponseWriter, r *http.Request) {
@@ -169,7 +272,11 @@ func (handle *Handle) startHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	glog.Info("Starting data stream")
-	handle.mc.SerialDevice.Write([]byte{openbci.Command["start"]})
+	err := handle.mc.setStreaming(true)
+	if err != nil {
+		glog.Errorf("error starting data stream: %s\n", err)
+		http.Error(w, err.Error(), 500)
+	}
 }
 
 func (handle *Handle) stopHandler(w http.ResponseWriter, r *http.Request) {
@@ -178,7 +285,11 @@ func (handle *Handle) stopHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	glog.Info("Stopping data stream")
-	handle.mc.SerialDevice.Write([]byte{openbci.Command["stop"]})
+	err := handle.mc.setStreaming(false)
+	if err != nil {
+		glog.Errorf("error stopping data stream: %s\n", err)
+		http.Error(w, err.Error(), 500)
+	}
 }
 
 func (handle *Handle) saveHandler(w http.ResponseWriter, r *http.Request) {
@@ -194,18 +305,105 @@ func (handle *Handle) saveHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (handle *Handle) captureHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	if handle.mc.capturing {
+		err := handle.mc.StopCapture()
+		if err != nil {
+			glog.Errorf("error closing capture: %s\n", err)
+		}
+		return
+	}
+	fn, err := handle.mc.StartCapture()
+	if err != nil {
+		glog.Errorf("error starting capture: %s\n", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	glog.Infof("Capturing device traffic to %s\n", fn)
+	fmt.Fprintln(w, fn)
+}
+
 func (handle *Handle) resetHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", 405)
 		return
 	}
-	resume := make(chan bool)
-	handle.mc.pauseRead <- resume
-	_, err := handle.mc.SerialDevice.Write([]byte{openbci.Command["reset"]})
+	info, err := handle.mc.Identify()
+	if err == errStreaming || err == errSavingBoard {
+		http.Error(w, err.Error(), 409)
+		return
+	} else if err == errDecoderPaused {
+		http.Error(w, err.Error(), 503)
+		return
+	} else if err != nil {
+		glog.Errorf("error reseting device: %s\n", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+//BoardInfo is the board being decoded and, once the board has been
+//reset, what its firmware reported
+type BoardInfo struct {
+	Board    Board
+	Firmware *FirmwareInfo `json:",omitempty"`
+}
+
+func (handle *Handle) infoHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	handle.mc.mu.Lock()
+	info := BoardInfo{handle.mc.board, handle.mc.firmware}
+	handle.mc.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+func (handle *Handle) executeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	cmd := Command{Bytes: r.FormValue("command")}
+	if cmd.Bytes == "" {
+		http.Error(w, "Bad Request, no command", 400)
+		return
+	}
+	for name, flag := range map[string]*bool{"pause": &cmd.Pause, "response": &cmd.Response} {
+		if v := r.FormValue(name); v != "" {
+			var err error
+			*flag, err = strconv.ParseBool(v)
+			if err != nil {
+				http.Error(w, "Bad Request, "+err.Error(), 400)
+				return
+			}
+		}
+	}
+	if v := r.FormValue("timeout"); v != "" {
+		var err error
+		cmd.Timeout, err = time.ParseDuration(v)
+		if err != nil {
+			http.Error(w, "Bad Request, "+err.Error(), 400)
+			return
+		}
+	}
+	//reading an answer back needs the decoder out of the way
+	cmd.Pause = cmd.Pause || cmd.Response
+	resp, err := handle.mc.Execute(cmd)
+	res := CommandResult{OK: err == nil, Response: resp}
 	if err != nil {
-		fmt.Printf("error reseting device: %s\n", err)
+		res.Error = err.Error()
 	}
-	resume <- true
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
 }
 
 func (handle *Handle) fftHandler(w http.ResponseWriter, r *http.Request) {
@@ -216,6 +414,10 @@ func (handle *Handle) fftHandler(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Path
 	p := strings.Split(path, "/")
 	data := strings.Split(p[2], "&")
+	if len(data) != 2 {
+		http.Error(w, "Bad Request, expected /fft/size&freq", 400)
+		return
+	}
 	fftsize, err := strconv.Atoi(data[0])
 	if err != nil {
 		http.Error(w, "Bad Request, only integers understood", 400)
@@ -226,5 +428,285 @@ func (handle *Handle) fftHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Bad Request, only integers understood", 400)
 		return
 	}
-	handle.mc.deltaFFT <- [2]int{fftsize, fftfreq}
+	if fftsize < 2 || fftfreq < 1 {
+		http.Error(w, "Bad Request, size must be at least 2 and freq at least 1", 400)
+		return
+	}
+	//the estimator is set with the form values window, segment, overlap
+	//and scale, those left out take their defaults
+	psd := defaultPSDConfig
+	if v := r.FormValue("window"); v != "" {
+		psd.Window = v
+	}
+	if v := r.FormValue("scale"); v != "" {
+		psd.Scale = v
+	}
+	if v := r.FormValue("segment"); v != "" {
+		psd.Segment, err = strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Bad Request, only integers understood", 400)
+			return
+		}
+	}
+	if v := r.FormValue("overlap"); v != "" {
+		psd.Overlap, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			http.Error(w, "Bad Request, "+err.Error(), 400)
+			return
+		}
+	}
+	err = psd.validate(fftsize)
+	if err != nil {
+		http.Error(w, "Bad Request, "+err.Error(), 400)
+		return
+	}
+	handle.mc.deltaFFT <- fftConfig{fftsize, fftfreq, psd}
+}
+
+func (handle *Handle) rateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	p := strings.Split(r.URL.Path, "/")
+	hz, err := strconv.Atoi(p[2])
+	if err != nil {
+		http.Error(w, "Bad Request, only integers understood", 400)
+		return
+	}
+	err = handle.mc.SetSampleRate(hz)
+	if err == errSavingRate {
+		http.Error(w, err.Error(), 409)
+		return
+	} else if err == errDecoderPaused {
+		http.Error(w, err.Error(), 503)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+}
+
+func (handle *Handle) configHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	config, err := handle.mc.QueryConfig()
+	if err == errStreaming {
+		http.Error(w, err.Error(), 409)
+		return
+	} else if err == errDecoderPaused {
+		http.Error(w, err.Error(), 503)
+		return
+	} else if err != nil {
+		glog.Errorf("error querying board config: %s\n", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config)
+}
+
+func (handle *Handle) impedanceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	var channels []int
+	for _, c := range strings.Split(r.FormValue("channels"), ",") {
+		ch, err := strconv.Atoi(c)
+		if err != nil {
+			http.Error(w, "Bad Request, channels must be a comma separated list of integers", 400)
+			return
+		}
+		channels = append(channels, ch)
+	}
+	duration := 10 * time.Second
+	if d := r.FormValue("duration"); d != "" {
+		var err error
+		duration, err = time.ParseDuration(d)
+		if err != nil {
+			http.Error(w, "Bad Request, "+err.Error(), 400)
+			return
+		}
+	}
+	//claimed here, a second request must not get past this before the
+	//measurement is under way
+	if handle.mc.claimMeasuring() != nil {
+		http.Error(w, "Conflict, stop the stream and any running measurement first", 409)
+		return
+	}
+	go func() {
+		err := handle.mc.measureImpedance(channels, duration)
+		if err != nil {
+			glog.Errorf("error measuring impedance: %s\n", err)
+			handle.mc.broadcastStatus("impedance measurement failed: "+err.Error(), true, 0)
+		}
+	}()
+	w.WriteHeader(202)
+}
+
+func (handle *Handle) genTestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	if handle.mc.genTesting {
+		err := handle.mc.StopGenTest()
+		if err == errNoDevice {
+			http.Error(w, "Conflict, "+err.Error(), 409)
+		}
+		return
+	}
+	config := DefaultGeneratorConfig(handle.mc.currentBoard())
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&config)
+		if err != nil {
+			http.Error(w, "Bad Request, "+err.Error(), 400)
+			return
+		}
+	}
+	err := handle.mc.StartGenTest(config)
+	if err != nil {
+		http.Error(w, "Bad Request, "+err.Error(), 400)
+	}
+}
+
+func (handle *Handle) replayHandler(w http.ResponseWriter, r *http.Request) {
+	replay := handle.mc.replay
+	if replay == nil {
+		http.Error(w, "Not found, the server is not replaying a recording", 404)
+		return
+	}
+	switch r.Method {
+	case "GET":
+	case "POST":
+		if v := r.FormValue("pause"); v != "" {
+			paused, err := strconv.ParseBool(v)
+			if err != nil {
+				http.Error(w, "Bad Request, "+err.Error(), 400)
+				return
+			}
+			replay.Pause(paused)
+		}
+		if v := r.FormValue("loop"); v != "" {
+			loop, err := strconv.ParseBool(v)
+			if err != nil {
+				http.Error(w, "Bad Request, "+err.Error(), 400)
+				return
+			}
+			replay.SetLoop(loop)
+		}
+		if v := r.FormValue("speed"); v != "" {
+			speed, err := strconv.ParseFloat(v, 64)
+			if err == nil {
+				err = replay.SetSpeed(speed)
+			}
+			if err != nil {
+				http.Error(w, "Bad Request, "+err.Error(), 400)
+				return
+			}
+		}
+		if v := r.FormValue("seek"); v != "" {
+			offset, err := time.ParseDuration(v)
+			if err == nil {
+				err = replay.Seek(offset)
+			}
+			if err != nil {
+				http.Error(w, "Bad Request, "+err.Error(), 400)
+				return
+			}
+		}
+	default:
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(replay.State())
+}
+
+func (handle *Handle) importHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	logFile, header, err := r.FormFile("log")
+	if err != nil {
+		http.Error(w, "Bad Request, the log file goes in the log field", 400)
+		return
+	}
+	defer logFile.Close()
+	var config []byte
+	if f, _, err := r.FormFile("config"); err == nil {
+		config, err = ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			http.Error(w, "Bad Request, "+err.Error(), 400)
+			return
+		}
+	}
+	current := handle.mc.currentBoard()
+	daisy := current.Daisy
+	if v := r.FormValue("daisy"); v != "" {
+		daisy, err = strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "Bad Request, "+err.Error(), 400)
+			return
+		}
+	}
+	rate := samplesPerSecond
+	if daisy {
+		rate /= 2
+	}
+	if v := r.FormValue("rate"); v != "" {
+		rate, err = strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Bad Request, only integers understood", 400)
+			return
+		}
+	}
+	//without a start the recording is taken to have ended on upload
+	var start time.Time
+	if v := r.FormValue("start"); v != "" {
+		start, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "Bad Request, "+err.Error(), 400)
+			return
+		}
+	}
+	policy := handle.mc.recovery
+	if v := r.FormValue("recover"); v != "" {
+		policy, err = ParseRecoveryPolicy(v)
+		if err != nil {
+			http.Error(w, "Bad Request, "+err.Error(), 400)
+			return
+		}
+	}
+	//without a config the log is taken to match this board's settings
+	gains := handle.mc.gains()
+	if daisy != current.Daisy {
+		gains = nil
+	}
+	board, gains, err := importBoard(config, daisy, rate, gains)
+	if err != nil {
+		http.Error(w, "Bad Request, "+err.Error(), 400)
+		return
+	}
+	wd, err := os.Getwd()
+	if err == nil {
+		wd += "/" + handle.mc.dataDir
+		err = os.MkdirAll(wd, 0777)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	result, err := ImportSDLog(logFile, sdLogName(wd, header.Filename), board, gains, policy, start, time.Now())
+	if err != nil {
+		http.Error(w, "Bad Request, "+err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
 }
@@ -36,8 +36,17 @@ var testsparsecommand = []testparsecommandpair{
 	{"/x/0/x0000000X", "x1000000Xx2000000Xx3000000Xx4000000Xx5000000Xx6000000Xx7000000Xx8000000X"},
 }
 
+var testsparsecommanddaisy = []testparsecommandpair{
+	{"/x/9/true", "Q"},
+	{"/x/16/false", "i"},
+	{"/x/10/x2060110X", "xW060110X"},
+	{"/x/17/x2060110X", ""},
+	{"/x/0/x0000000X", "x1000000Xx2000000Xx3000000Xx4000000Xx5000000Xx6000000Xx7000000Xx8000000X" +
+		"xQ000000XxW000000XxE000000XxR000000XxT000000XxY000000XxU000000XxI000000X"},
+}
+
 func TestParseComman(t *testing.T) {
-	handle := NewHandle(&MindControl{})
+	handle := NewHandle(NewMindControl(nil, nil, nil, nil, Cyton, RecoverRepeat))
 	for _, pair := range testsparsecommand {
 		res := handle.parseCommand(pair.data)
 		if res != pair.result {
@@ -49,3 +58,20 @@ func TestParseComman(t *testing.T) {
 		}
 	}
 }
+
+func TestParseCommandDaisy(t *testing.T) {
+	handle := NewHandle(NewMindControl(nil, nil, nil, nil, CytonDaisy, RecoverRepeat))
+	for _, pair := range testsparsecommanddaisy {
+		res := handle.parseCommand(pair.data)
+		if res != pair.result {
+			t.Error(
+				"For", pair.data,
+				"Expected", pair.result,
+				"Got", res,
+			)
+		}
+	}
+	if handle.mc.gain[15] != 1.0 {
+		t.Error("Expected gain of channel 16 to be 1, got", handle.mc.gain[15])
+	}
+}
@@ -0,0 +1,184 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kevinjos/openbci-driver"
+)
+
+//The lead-off drive injects leadOffCurrent at leadOffFreq into the
+//electrode, the board has seriesResistor in line with every input
+const (
+	leadOffCurrent = 6e-9
+	leadOffFreq    = 31.5
+	seriesResistor = 2200
+)
+
+//impedanceWindow is the length of one impedance estimate, a whole
+//number of lead-off cycles keeps the tone in a single bin
+const impedanceWindow = 2 * time.Second
+
+var errMeasuring = errors.New("impedance measurement already running")
+
+//leadOffCommand turns the lead-off drive on the P input of channel on or off
+func leadOffCommand(channel int, on bool) string {
+	p := "0"
+	if on {
+		p = "1"
+	}
+	return "z" + channelIDs[channel-1:channel] + p + "0Z"
+}
+
+//toneAmplitude returns the peak amplitude of the freq component of x
+//using the Goertzel algorithm on the mean removed signal
+func toneAmplitude(x []float64, freq float64, rate float64) float64 {
+	var mean float64
+	for _, v := range x {
+		mean += v
+	}
+	mean /= float64(len(x))
+	coeff := 2 * math.Cos(2*math.Pi*freq/rate)
+	var s1, s2 float64
+	for _, v := range x {
+		s0 := v - mean + coeff*s1 - s2
+		s2, s1 = s1, s0
+	}
+	power := s1*s1 + s2*s2 - coeff*s1*s2
+	return 2 * math.Sqrt(power) / float64(len(x))
+}
+
+//impedanceKOhm converts the lead-off tone amplitude in microvolts to
+//the electrode impedance in kOhm
+func impedanceKOhm(amplitude float64) float64 {
+	ohm := amplitude*1e-6/leadOffCurrent - seriesResistor
+	if ohm < 0 {
+		ohm = 0
+	}
+	return ohm / 1000
+}
+
+//MeasureImpedance drives the lead-off current into channels, broadcasts
+//the impedance of each in kOhm for every window until duration has
+//passed and then puts the channel settings back the way they were.
+func (mc *MindControl) MeasureImpedance(channels []int, duration time.Duration) error {
+	err := mc.claimMeasuring()
+	if err != nil {
+		return err
+	}
+	return mc.measureImpedance(channels, duration)
+}
+
+//claimMeasuring takes the board for a measurement, one at a time and
+//none while streaming
+func (mc *MindControl) claimMeasuring() error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.measuring {
+		return errMeasuring
+	}
+	if mc.streaming {
+		return errStreaming
+	}
+	mc.measuring = true
+	return nil
+}
+
+//measureImpedance is MeasureImpedance once claimMeasuring succeeded, the
+//claim is given up when it returns
+func (mc *MindControl) measureImpedance(channels []int, duration time.Duration) error {
+	defer func() {
+		mc.mu.Lock()
+		mc.measuring = false
+		mc.mu.Unlock()
+	}()
+	board := mc.currentBoard()
+	for _, ch := range channels {
+		if ch < 1 || ch > board.Channels {
+			return fmt.Errorf("no channel %d on the board", ch)
+		}
+	}
+	previous, err := mc.QueryConfig()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		restore := []string{string(openbci.Command["stop"])}
+		for _, ch := range channels {
+			restore = append(restore, leadOffCommand(ch, false))
+		}
+		for _, ch := range channels {
+			restore = append(restore, previous.Channels[ch-1].command())
+		}
+		//every command is tried, a failed one may leave the drive on
+		var failed error
+		for _, command := range restore {
+			err := mc.writeCommand(command)
+			if err != nil {
+				glog.Errorf("error restoring the channels with %s: %s\n", command, err)
+				failed = err
+			}
+		}
+		if failed != nil {
+			mc.broadcastStatus("impedance measurement done, restoring the channels failed: "+failed.Error(), true, 0)
+			return
+		}
+		mc.broadcastStatus("impedance measurement done", true, 0)
+	}()
+	for _, ch := range channels {
+		err = mc.writeCommand(leadOffCommand(ch, true))
+		if err != nil {
+			return err
+		}
+	}
+	err = mc.writeCommand(string(openbci.Command["start"]))
+	if err != nil {
+		return err
+	}
+
+	window := int(impedanceWindow.Seconds() * float64(board.SamplesPerSecond))
+	samples := make([][]float64, len(channels))
+	timeout := time.After(duration)
+	for {
+		select {
+		case <-timeout:
+			return nil
+		case s := <-mc.impedanceChan:
+			for i, ch := range channels {
+				samples[i] = append(samples[i], s.Microvolts[ch-1])
+			}
+			if len(samples[0]) < window {
+				continue
+			}
+			msg := make(map[string][]float64)
+			for i, ch := range channels {
+				amp := toneAmplitude(samples[i], leadOffFreq, float64(board.SamplesPerSecond))
+				msg[chanName(ch-1)] = []float64{impedanceKOhm(amp)}
+				samples[i] = samples[i][:0]
+			}
+			glog.V(1).Infof("impedance %v\n", msg)
+			mc.broadcast <- newMessage("impedance", msg)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestToneAmplitude(t *testing.T) {
+	const rate = 250
+	for _, amp := range []float64{0, 1, 62.5} {
+		x := make([]float64, 2*rate)
+		for i := range x {
+			ts := float64(i) / rate
+			x[i] = 100 + amp*math.Sin(2*math.Pi*leadOffFreq*ts) + 20*math.Sin(2*math.Pi*10*ts)
+		}
+		if res := toneAmplitude(x, leadOffFreq, rate); math.Abs(res-amp) > 1e-6*(1+amp) {
+			t.Error("For amplitude", amp, "got", res)
+		}
+	}
+}
+
+func TestImpedanceKOhm(t *testing.T) {
+	//10 kOhm plus the series resistor at 6 nA
+	amp := (10000 + seriesResistor) * leadOffCurrent * 1e6
+	if res := impedanceKOhm(amp); math.Abs(res-10) > 1e-9 {
+		t.Error("For", amp, "uV expected 10 kOhm got", res)
+	}
+	if res := impedanceKOhm(0); res != 0 {
+		t.Error("For 0 uV expected 0 kOhm got", res)
+	}
+}
+
+func TestChannelSettingsCommand(t *testing.T) {
+	config, err := parseRegisters(testRegisterDump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"x1060111X", "x2060001X", "x3160111X", "x4001101X", "x5015101X"}
+	for i, cmd := range expected {
+		if res := config.Channels[i].command(); res != cmd {
+			t.Error("For channel", i+1, "expected", cmd, "got", res)
+		}
+	}
+	if res := leadOffCommand(9, true) + leadOffCommand(1, false); res != "zQ10Zz100Z" {
+		t.Error("For lead-off commands expected zQ10Zz100Z got", res)
+	}
+}
+
+//restoreFailingDevice takes commands until the stream is stopped
+type restoreFailingDevice struct {
+	testDevice
+	stopped bool
+}
+
+func (d *restoreFailingDevice) Write(p []byte) (int, error) {
+	if d.stopped {
+		return 0, errors.New("write failed")
+	}
+	d.stopped = string(p) == "s"
+	return d.testDevice.Write(p)
+}
+
+func TestMeasureImpedanceRestoreFails(t *testing.T) {
+	device := &restoreFailingDevice{testDevice: testDevice{r: strings.NewReader(testRegisterDump + responseEnd)}}
+	broadcast := make(chan *message, 8)
+	mc := NewMindControl(broadcast, nil, device, nil, Cyton, RecoverRepeat)
+	go mc.runCommands()
+	defer close(mc.quitDecodeStream)
+	go func() {
+		resume := <-mc.pauseRead
+		<-resume
+		<-mc.gainC
+	}()
+	err := mc.MeasureImpedance([]int{1}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := <-broadcast
+	if !strings.Contains(m.Status, "restoring the channels failed") || mc.isMeasuring() {
+		t.Error("For a failed restore expected it reported got", m.Status, mc.isMeasuring())
+	}
+}
+
+func TestImpedanceHandlerClaims(t *testing.T) {
+	broadcast := make(chan *message, 8)
+	mc := NewMindControl(broadcast, nil, &testDevice{r: strings.NewReader("")}, nil, Cyton, RecoverRepeat)
+	go mc.runCommands()
+	defer close(mc.quitDecodeStream)
+	handle := NewHandle(mc)
+	var codes []int
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handle.impedanceHandler(w, httptest.NewRequest("POST", "/impedance?channels=1", nil))
+		codes = append(codes, w.Code)
+	}
+	if codes[0] != 202 || codes[1] != 409 {
+		t.Error("For two measurements at once expected 202 409 got", codes)
+	}
+	//without a decoder the first one fails to read the registers
+	select {
+	case <-broadcast:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the measurement to end")
+	}
+}
@@ -20,7 +20,6 @@ package main
 
 import (
 	"flag"
-	"io"
 	"log"
 	"net/http"
 	"os"
@@ -28,13 +27,21 @@ import (
 	"time"
 
 	"github.com/golang/glog"
-	"github.com/kevinjos/openbci-driver"
 )
 
 var (
 	addr        = flag.String("addr", "", "http service address")
-	location    = flag.String("loc", "", "serial mount point")
+	location    = flag.String("loc", "", "serial mount point or tcp://host[:port] of a wifi shield, a synthetic signal generator is used when empty")
 	baud        = flag.Int("baud", 115200, "serial baud rate")
+	daisy       = flag.Bool("daisy", false, "board has the daisy module attached")
+	recovery    = flag.String("recover", "repeat", "lost sample recovery: repeat, interpolate, zero, nan or drop")
+	replayFile  = flag.String("replay", "", "play a BDF or EDF recording back in place of a device")
+	speed       = flag.Float64("speed", 1, "replay speed relative to the recording's sample rate")
+	loop        = flag.Bool("loop", false, "start the replay over at the end of the recording")
+	playback    = flag.String("playback", "", "play a raw capture file back in place of a device")
+	mains       = flag.Float64("mains", 0, "mains frequency to notch out, 50 or 60 Hz, 0 for none")
+	harmonics   = flag.Int("harmonics", 0, "harmonics of the mains frequency to notch out as well")
+	devices     = flag.String("devices", "", "several boards as id=location,... with ?daisy=true for a daisy board")
 	versionFlag = flag.Bool("version", false, "Print version info and exit.")
 	cpuprofile  = flag.String("cpuprofile", "", "write cpu profile to file")
 	readTimeout = time.Millisecond
@@ -42,7 +49,6 @@ var (
 )
 
 const (
-	channels         = 8
 	samplesPerSecond = 250
 	readBufferSize   = 1024 * 1024
 	RawMsgSize       = 30
@@ -59,10 +65,11 @@ func init() {
 
 func main() {
 	defer glog.Flush()
+	if flag.Arg(0) == "import" {
+		glog.Flush()
+		os.Exit(importCommand(flag.Args()[1:]))
+	}
 	glog.Infoln("Starting eeg-server")
-	h := NewHub()
-	defer h.Close()
-
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
@@ -72,41 +79,45 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	var (
-		device io.ReadWriteCloser
-		err    error
-	)
-	if *location == "" {
-		device = openbci.NewMockDevice()
-	} else {
-		device, err = openbci.NewDevice(*location, *baud, readTimeout)
+	policy, err := ParseRecoveryPolicy(*recovery)
+	if err != nil {
+		glog.Fatalln(err)
+	}
+
+	//the flags describe a single device that keeps the data/ directory
+	//to itself, with -devices each gets a directory of its own
+	loc := *location
+	switch {
+	case *replayFile != "":
+		loc = "replay:" + *replayFile
+	case *playback != "":
+		loc = "capture:" + *playback
+	}
+	specs := []DeviceSpec{{ID: "default", Location: loc, Daisy: *daisy}}
+	if *devices != "" {
+		specs, err = ParseDeviceSpecs(*devices)
 		if err != nil {
-			glog.Fatalf("error opening device: %s\n", err)
+			glog.Fatalln(err)
 		}
 	}
-	defer device.Close()
-
-	shutdown := make(chan bool, 1)
-	mc := NewMindControl(h.broadcast, shutdown, device)
-	handle := NewHandle(mc)
 
-	http.HandleFunc("/ws", h.wsPacketHandler)
-
-	http.HandleFunc("/", handle.rootHandler)
-	http.HandleFunc("/x/", handle.commandHandler)
-	http.HandleFunc("/fft/", handle.fftHandler)
-	http.HandleFunc("/reset", handle.resetHandler)
-	http.HandleFunc("/start", handle.startHandler)
-	http.HandleFunc("/stop", handle.stopHandler)
-	http.HandleFunc("/close", handle.closeHandler)
-	http.HandleFunc("/save", handle.saveHandler)
-	http.HandleFunc("/js/", handle.jsHandler)
-	http.HandleFunc("/static/", handle.cssHandler)
-	http.HandleFunc("/bootstrap/", handle.bootstrapHandler)
-	http.HandleFunc("/js/libs/", handle.libsHandler)
-
-	go h.Run()
-	go mc.Start()
+	ds := newDeviceSet()
+	defer ds.Close()
+	for _, spec := range specs {
+		dataDir := "data"
+		if *devices != "" {
+			dataDir += "/" + spec.ID
+		}
+		d, err := OpenDevice(spec, policy, dataDir)
+		if err != nil {
+			glog.Fatalf("device %s: %s\n", spec.ID, err)
+		}
+		defer d.mc.SerialDevice.Close()
+		ds.add(d)
+	}
+	http.Handle("/devices", ds)
+	http.Handle("/devices/", ds)
+	http.Handle("/", ds.root(specs[0].ID))
 
 	run := func(shutdown <-chan bool) {
 		go http.ListenAndServe(*addr, nil)
@@ -117,6 +128,6 @@ func main() {
 			}
 		}
 	}
-	run(shutdown)
+	run(ds.shutdown)
 	glog.Infoln("Stoping eeg-server")
 }
@@ -0,0 +1,148 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+const (
+	//notchQ is the quality factor of the band-stop resonators, high
+	//enough to leave the neighbouring EEG bands alone
+	notchQ = 30
+	//maxHarmonics caps the harmonics notched on top of the fundamental
+	maxHarmonics = 10
+)
+
+var errSavingNotch = errors.New("cannot change the mains filter while saving")
+
+//MainsNotch removes mains interference at Freq and the first Harmonics
+//multiples of it. A Freq of 0 turns it off.
+type MainsNotch struct {
+	Freq      float64
+	Harmonics int
+}
+
+func (n MainsNotch) validate() error {
+	if n.Freq != 0 && n.Freq != 50 && n.Freq != 60 {
+		return fmt.Errorf("mains frequency %g Hz not one of 0, 50 or 60", n.Freq)
+	}
+	if n.Harmonics < 0 || n.Harmonics > maxHarmonics {
+		return fmt.Errorf("harmonics %d out of range 0-%d", n.Harmonics, maxHarmonics)
+	}
+	return nil
+}
+
+//freqs are the frequencies notched at rate, those at or above the
+//Nyquist frequency are left out
+func (n MainsNotch) freqs(rate int) []float64 {
+	var freqs []float64
+	for k := 1; n.Freq > 0 && k <= n.Harmonics+1; k++ {
+		f := float64(k) * n.Freq
+		if f >= float64(rate)/2 {
+			break
+		}
+		freqs = append(freqs, f)
+	}
+	return freqs
+}
+
+//specs is the gofidlib chain for n at rate
+func (n MainsNotch) specs(rate int) []string {
+	var specs []string
+	for _, f := range n.freqs(rate) {
+		specs = append(specs, "BsRe/"+strconv.Itoa(notchQ)+"/"+strconv.FormatFloat(f, 'g', -1, 64))
+	}
+	return specs
+}
+
+//note describes n at rate for the header of a recording, whose samples
+//are stored without it, e.g. "view-notch:60Hz+1-harmonics,not-applied".
+//It has no spaces as it is one subfield of the recording identification.
+func (n MainsNotch) note(rate int) string {
+	freqs := n.freqs(rate)
+	if len(freqs) == 0 {
+		return ""
+	}
+	return "view-notch:" + strconv.FormatFloat(n.Freq, 'g', -1, 64) + "Hz+" +
+		strconv.Itoa(len(freqs)-1) + "-harmonics,not-applied"
+}
+
+//notchChains designs n at rate for every channel
+func notchChains(n MainsNotch, rate int, channels int) []*filterChain {
+	specs := make([][]string, channels)
+	for ch := range specs {
+		specs[ch] = n.specs(rate)
+	}
+	return newFilterChains(specs, rate)
+}
+
+//SetNotch switches the mains filter of every channel to n. It runs on
+//the raw stream and the FFT input ahead of the channel filters. The
+//setting goes into the header of recordings, so it cannot change while
+//saving. Channels still on the default chain move to the default chain
+//for n, the others keep what /filters gave them.
+func (mc *MindControl) SetNotch(n MainsNotch) (MainsNotch, error) {
+	mc.mu.Lock()
+	board := mc.board
+	old := mc.notch
+	mc.mu.Unlock()
+	err := n.validate()
+	if err != nil {
+		return old, err
+	}
+	if mc.saving {
+		return old, errSavingNotch
+	}
+	rate := board.SamplesPerSecond
+	c, err := newFilterChain(n.specs(rate), rate)
+	if err != nil {
+		return old, err
+	}
+	c.free()
+	update := filterUpdate{rate: rate, chains: make(map[int]*filterChain)}
+	oldDefault := defaultFilterSpecs(1, old)[0]
+	newDefault := defaultFilterSpecs(1, n)[0]
+	mc.mu.Lock()
+	for ch, specs := range mc.filterSpecs {
+		if !reflect.DeepEqual(specs, oldDefault) || reflect.DeepEqual(specs, newDefault) {
+			continue
+		}
+		c, err := newFilterChain(newDefault, rate)
+		if err != nil {
+			mc.mu.Unlock()
+			for _, c := range update.chains {
+				c.free()
+			}
+			return old, err
+		}
+		update.chains[ch] = c
+	}
+	for ch := range update.chains {
+		mc.filterSpecs[ch] = append([]string{}, newDefault...)
+	}
+	mc.notch = n
+	mc.mu.Unlock()
+	update.notch = notchChains(n, rate, board.Channels)
+	mc.filterC <- update
+	return n, nil
+}
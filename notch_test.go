@@ -0,0 +1,102 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testnotchpair struct {
+	notch MainsNotch
+	rate  int
+	specs []string
+	note  string
+}
+
+var testsnotch = []testnotchpair{
+	{MainsNotch{}, 250, nil, ""},
+	{MainsNotch{60, 0}, 250, []string{"BsRe/30/60"}, "view-notch:60Hz+0-harmonics,not-applied"},
+	{MainsNotch{50, 2}, 250, []string{"BsRe/30/50", "BsRe/30/100"}, "view-notch:50Hz+1-harmonics,not-applied"},
+	{MainsNotch{60, 3}, 1000, []string{"BsRe/30/60", "BsRe/30/120", "BsRe/30/180", "BsRe/30/240"}, "view-notch:60Hz+3-harmonics,not-applied"},
+	{MainsNotch{60, 3}, 125, []string{"BsRe/30/60"}, "view-notch:60Hz+0-harmonics,not-applied"},
+	{MainsNotch{60, 3}, 100, nil, ""},
+}
+
+func TestNotchSpecs(t *testing.T) {
+	for _, pair := range testsnotch {
+		specs, note := pair.notch.specs(pair.rate), pair.notch.note(pair.rate)
+		if !reflect.DeepEqual(specs, pair.specs) || note != pair.note {
+			t.Error("For", pair.notch, pair.rate, "expected", pair.specs, pair.note, "got", specs, note)
+		}
+	}
+}
+
+func TestSetNotch(t *testing.T) {
+	for notch, ok := range map[MainsNotch]bool{
+		{60, 2}: true, {50, 0}: true, {0, 0}: true,
+		{55, 0}: false, {60, -1}: false, {50, maxHarmonics + 1}: false,
+	} {
+		mc := NewMindControl(nil, nil, nil, nil, CytonDaisy, RecoverRepeat)
+		updates := make(chan filterUpdate, 1)
+		go func() { updates <- <-mc.filterC }()
+		res, err := mc.SetNotch(notch)
+		if (err == nil) != ok {
+			t.Error("For", notch, "expected ok", ok, "got", err)
+			continue
+		}
+		if ok && (res != notch || mc.notch != notch || len((<-updates).notch) != 16) {
+			t.Error("For", notch, "expected 16 notch chains got", res, mc.notch)
+		}
+	}
+	mc := NewMindControl(nil, nil, nil, nil, Cyton, RecoverRepeat)
+	mc.filterSpecs[1] = []string{"LpBe4/40"}
+	updates := make(chan filterUpdate, 1)
+	go func() { updates <- <-mc.filterC }()
+	if _, err := mc.SetNotch(MainsNotch{60, 0}); err != nil {
+		t.Fatal(err)
+	}
+	u := <-updates
+	for ch, specs := range mc.Filters() {
+		expected := []string{notchedFilterSpec}
+		if ch == 1 {
+			expected = []string{"LpBe4/40"}
+		}
+		if !reflect.DeepEqual(specs.Specs, expected) || (u.chains[ch] != nil) != (ch != 1) {
+			t.Error("For", chanName(ch), "expected", expected, "got", specs.Specs, "new chain", u.chains[ch] != nil)
+		}
+	}
+	mc.saving = true
+	if _, err := mc.SetNotch(MainsNotch{50, 0}); err != errSavingNotch {
+		t.Error("For a notch while saving expected", errSavingNotch, "got", err)
+	}
+}
+
+func TestRecordID(t *testing.T) {
+	start := time.Date(2016, time.March, 2, 0, 0, 0, 0, time.UTC)
+	for notch, expected := range map[string]string{
+		"":                          "Startdate 02-MAR-2016",
+		MainsNotch{50, 0}.note(250): "Startdate 02-MAR-2016 X X X view-notch:50Hz+0-harmonics,not-applied",
+	} {
+		if res := recordID(start, notch); res != expected {
+			t.Error("For", notch, "expected", expected, "got", res)
+		}
+	}
+}
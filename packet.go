@@ -19,31 +19,38 @@
 package main
 
 import (
-	"math/cmplx"
+	"math"
 	"strconv"
+	"time"
 
 	"github.com/kevinjos/eeg-web-server/int24"
-	"github.com/runningwild/go-fftw/fftw"
 )
 
 type PacketBatcher struct {
 	Chans         map[string][]float64
 	FFTs          map[string][]float64
+	Aux           map[string][]float64
 	SignalQuality float64
 	packets       []*Packet
 	size          int
+	psd           *welchEstimator
 }
 
-func NewPacketBatcher(size int) *PacketBatcher {
+func NewPacketBatcher(size int, channels int) *PacketBatcher {
 	chans := make(map[string][]float64)
 	ffts := make(map[string][]float64)
-	for i := 1; i <= channels; i++ {
-		chans["Chan"+strconv.Itoa(i)] = make([]float64, size)
-		ffts["Chan"+strconv.Itoa(i)] = make([]float64, size/2)
+	aux := make(map[string][]float64)
+	for i := 0; i < channels; i++ {
+		chans[chanName(i)] = make([]float64, size)
+		ffts[chanName(i)] = make([]float64, size/2)
+	}
+	for key := range NewPacket().auxValues() {
+		aux[key] = make([]float64, size)
 	}
 	return &PacketBatcher{
 		Chans:   chans,
 		FFTs:    ffts,
+		Aux:     aux,
 		packets: make([]*Packet, size),
 		size:    size,
 	}
@@ -51,48 +58,102 @@ func NewPacketBatcher(size int) *PacketBatcher {
 
 func (pb *PacketBatcher) batch() {
 	for i, p := range pb.packets {
-		pb.Chans["Chan1"][i] = p.Chan1
-		pb.Chans["Chan2"][i] = p.Chan2
-		pb.Chans["Chan3"][i] = p.Chan3
-		pb.Chans["Chan4"][i] = p.Chan4
-		pb.Chans["Chan5"][i] = p.Chan5
-		pb.Chans["Chan6"][i] = p.Chan7
-		pb.Chans["Chan7"][i] = p.Chan7
-		pb.Chans["Chan8"][i] = p.Chan8
+		for j, val := range p.Microvolts {
+			//JSON has no NaN, gaps filled with NaN are published as zero
+			if math.IsNaN(val) {
+				val = 0
+			}
+			pb.Chans[chanName(j)][i] = val
+		}
+		for key, val := range p.auxValues() {
+			pb.Aux[key][i] = val
+		}
 	}
 	// pb.deleteEmptyChans()
 }
 
-func (pb *PacketBatcher) setFFT() {
+//setPSD estimates the power spectral density of every channel. The
+//estimator is kept for the next call as long as c stays the same. FFTs
+//is replaced rather than written to when published, the last one may
+//still be queued for the websocket sessions. The spectra of a batcher
+//that is never published are written in place.
+func (pb *PacketBatcher) setPSD(rate int, c PSDConfig, published bool) {
+	if pb.psd == nil || pb.psd.config != c {
+		pb.free()
+		pb.psd = newWelchEstimator(c, pb.size)
+	}
+	bins := pb.psd.bins()
+	ffts := pb.FFTs
+	if published || len(ffts) != len(pb.Chans) || len(ffts[chanName(0)]) != bins {
+		//one block for all channels rather than one per channel
+		spectra := make([]float64, len(pb.Chans)*bins)
+		ffts = make(map[string][]float64, len(pb.Chans))
+		for key := range pb.Chans {
+			ffts[key], spectra = spectra[:bins:bins], spectra[bins:]
+		}
+	}
 	for key, val := range pb.Chans {
-		mirrored := pb.dft(val)
-		pb.FFTs[key] = mirrored[:len(mirrored)/2]
-		normalizeInPlace(pb.FFTs[key])
+		pb.psd.estimate(val, rate, ffts[key])
+	}
+	pb.FFTs = ffts
+}
+
+//sample is the packet at i owned by the batcher, for batches of values
+//that are not in the packets passed around. It is reused once the
+//batcher comes back to i.
+func (pb *PacketBatcher) sample(i int, channels int) *Packet {
+	p := pb.packets[i]
+	if p == nil || len(p.Microvolts) != channels {
+		p = &Packet{Sample: NewSample(channels)}
+		pb.packets[i] = p
 	}
+	return p
 }
 
-func (pb *PacketBatcher) dft(input []float64) []float64 {
-	data := fftw.NewArray(pb.size)
-	for idx, val := range input {
-		data.Set(idx, complex(val, 0.0))
+//free releases the transform of the batcher
+func (pb *PacketBatcher) free() {
+	if pb.psd != nil {
+		pb.psd.free()
+		pb.psd = nil
 	}
-	forward := fftw.NewPlan(data, data, fftw.Forward, fftw.Estimate)
-	defer forward.Destroy()
-	forward.Execute()
-	data_out := make([]float64, pb.size)
-	for idx, val := range data.Elems {
-		data_out[idx] = cmplx.Abs(val)
+}
+
+//Sample holds one reading of every channel on the board, scaled to
+//microvolts and as the signed 24 bit counts reported by the ADS1299
+type Sample struct {
+	Microvolts []float64
+	Counts     []int32
+}
+
+//NewSample allocates a sample for the given number of channels
+func NewSample(channels int) Sample {
+	return Sample{
+		Microvolts: make([]float64, channels),
+		Counts:     make([]int32, channels),
 	}
-	return data_out
+}
+
+//Copy returns a sample that shares no memory with s
+func (s Sample) Copy() Sample {
+	c := NewSample(len(s.Microvolts))
+	copy(c.Microvolts, s.Microvolts)
+	copy(c.Counts, s.Counts)
+	return c
 }
 
 type Packet struct {
-	header, footer, seqNum                                         byte
-	Chan1, Chan2, Chan3, Chan4, Chan5, Chan6, Chan7, Chan8         float64
-	Rchan1, Rchan2, Rchan3, Rchan4, Rchan5, Rchan6, Rchan7, Rchan8 []byte
-	AccX, AccY, AccZ                                               int16
-	SignalQuality                                                  uint8
-	Synced                                                         bool
+	header, footer, seqNum byte
+	Sample
+	AuxFormat        AuxFormat
+	AccX, AccY, AccZ int16
+	Aux              []uint16
+	BoardTime        uint32
+	SignalQuality    uint8
+	Synced           bool
+	Lost             uint8
+	Synthesized      bool
+	Received         time.Time
+	Timestamp        time.Time
 }
 
 func NewPacket() *Packet {
@@ -105,50 +166,71 @@ func NewPacket() *Packet {
 
 func (p *Packet) RawChans() map[string][]float64 {
 	m := make(map[string][]float64)
-	m["Chan1"] = []float64{p.Chan1}
-	m["Chan2"] = []float64{p.Chan2}
-	m["Chan3"] = []float64{p.Chan3}
-	m["Chan4"] = []float64{p.Chan4}
-	m["Chan5"] = []float64{p.Chan5}
-	m["Chan6"] = []float64{p.Chan6}
-	m["Chan7"] = []float64{p.Chan7}
-	m["Chan8"] = []float64{p.Chan8}
+	for i, val := range p.Microvolts {
+		m[chanName(i)] = []float64{val}
+	}
 	return m
 }
 
-func encodePacket(p *[33]byte, sq byte, gain *[8]float64, synced bool) *Packet {
+//encodePacket scales the eight channels of frame p and decodes its aux
+//bytes according to the stop byte. When a daisy frame d is given its
+//channels are appended as channels 9-16 and the accelerometer values of
+//the two frames are merged.
+func encodePacket(p *[33]byte, d *[33]byte, sq byte, gain []float64, synced bool) *Packet {
+	frames := []*[33]byte{p}
+	if d != nil {
+		frames = append(frames, d)
+	}
 	packet := NewPacket()
 	packet.seqNum = p[1]
-	packet.Chan1 = scaleToMicroVolts(int24.UnmarshalSBE(p[2:5]), gain[0])
-	packet.Rchan1 = p[2:5]
-	packet.Chan2 = scaleToMicroVolts(int24.UnmarshalSBE(p[5:8]), gain[1])
-	packet.Rchan2 = p[5:8]
-	packet.Chan3 = scaleToMicroVolts(int24.UnmarshalSBE(p[8:11]), gain[2])
-	packet.Rchan3 = p[8:11]
-	packet.Chan4 = scaleToMicroVolts(int24.UnmarshalSBE(p[11:14]), gain[3])
-	packet.Rchan4 = p[11:14]
-	packet.Chan5 = scaleToMicroVolts(int24.UnmarshalSBE(p[14:17]), gain[4])
-	packet.Rchan5 = p[14:17]
-	packet.Chan6 = scaleToMicroVolts(int24.UnmarshalSBE(p[17:20]), gain[5])
-	packet.Rchan6 = p[17:20]
-	packet.Chan7 = scaleToMicroVolts(int24.UnmarshalSBE(p[20:23]), gain[6])
-	packet.Rchan7 = p[20:23]
-	packet.Chan8 = scaleToMicroVolts(int24.UnmarshalSBE(p[23:26]), gain[7])
-	packet.Rchan8 = p[23:26]
-	packet.AccX = convert16bitTo32bit(p[26:28])
-	packet.AccY = convert16bitTo32bit(p[28:30])
-	packet.AccZ = convert16bitTo32bit(p[30:32])
+	packet.Sample = NewSample(8 * len(frames))
+	for f, frame := range frames {
+		for i := 0; i < 8; i++ {
+			ch := f*8 + i
+			packet.Counts[ch] = int24.UnmarshalSBE(frame[2+3*i : 5+3*i])
+			packet.Microvolts[ch] = scaleToMicroVolts(packet.Counts[ch], gain[ch])
+		}
+	}
+	if d != nil {
+		decodeAux(packet, d)
+	}
+	accX, accY, accZ := packet.AccX, packet.AccY, packet.AccZ
+	decodeAux(packet, p)
+	if d != nil && packet.AuxFormat == AuxAccel && auxFormat(d[32]) == AuxAccel {
+		packet.AccX = int16((int(accX) + int(packet.AccX)) / 2)
+		packet.AccY = int16((int(accY) + int(packet.AccY)) / 2)
+		packet.AccZ = int16((int(accZ) + int(packet.AccZ)) / 2)
+	}
 	packet.SignalQuality = sq
 	packet.Synced = synced
 	return packet
 }
 
+//chanName is the key a channel is published under, starting at Chan1
+func chanName(i int) string {
+	return "Chan" + strconv.Itoa(i+1)
+}
+
 //At 24x gain, the possible range is +/-187,500uV
 func scaleToMicroVolts(c int32, gain float64) float64 {
 	scaleFac := 4.5 / gain / ((1 << 23) - 1)
 	return scaleFac * float64(c) * 1000000
 }
 
+//countsFromMicroVolts is the inverse of scaleToMicroVolts, clamped to
+//the range of the 24 bit ADC
+func countsFromMicroVolts(uv float64, gain float64) int32 {
+	scaleFac := 4.5 / gain / ((1 << 23) - 1)
+	c := math.Round(uv / 1000000 / scaleFac)
+	switch {
+	case c > (1<<23)-1:
+		return (1 << 23) - 1
+	case c < -(1 << 23):
+		return -(1 << 23)
+	}
+	return int32(c)
+}
+
 //conver16bitTo32bit takes a byte slice of len 2
 //and converts the 16bit 2's complement integer
 //to the type int32 representation
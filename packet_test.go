@@ -19,7 +19,11 @@
 package main
 
 import (
+	"bytes"
 	"testing"
+	"time"
+
+	"github.com/kevinjos/openbci-driver"
 )
 
 type test16pair struct {
@@ -108,3 +112,151 @@ func TestNewPacket(t *testing.T) {
 		)
 	}
 }
+
+func TestEncodePacketDaisy(t *testing.T) {
+	var board, daisy [33]byte
+	board[1], daisy[1] = 1, 2
+	board[2+3*7+2], daisy[2+3*7+2] = 1, 2
+	board[27], daisy[27] = 2, 4
+	board[32], daisy[32] = 0xc0, 0xc0
+	p := encodePacket(&board, &daisy, 100, CytonDaisy.newGains(), true)
+	if len(p.Microvolts) != 16 {
+		t.Fatal("expected 16 channels, got", len(p.Microvolts))
+	}
+	if p.Microvolts[7] != scaleToMicroVolts(1, 24) || p.Microvolts[15] != scaleToMicroVolts(2, 24) {
+		t.Error(
+			"For channels 8 and 16",
+			"expected", scaleToMicroVolts(1, 24), "and", scaleToMicroVolts(2, 24),
+			"got", p.Microvolts[7], "and", p.Microvolts[15],
+		)
+	}
+	if p.AccX != 3 {
+		t.Error("For averaged AccX expected 3 got", p.AccX)
+	}
+}
+
+//TestDecodeDaisyOrder has the odd frame carry channels 1-8 and the even
+//frame following it channels 9-16, the order the firmware sends them in
+func TestDecodeDaisyOrder(t *testing.T) {
+	var stream []byte
+	for seq := byte(1); seq <= 8; seq++ {
+		counts := make([]int32, 8)
+		for ch := range counts {
+			counts[ch] = int32(seq)
+			if seq%2 == 0 {
+				counts[ch] = -int32(seq)
+			}
+		}
+		frame := marshalFrame(seq, counts, [6]byte{}, openbci.Command["footer"])
+		stream = append(stream, frame[:]...)
+	}
+	packets := make(chan *Packet, 4)
+	quit := make(chan bool)
+	defer close(quit)
+	device := &testDevice{r: bytes.NewReader(stream)}
+	go DecodeStream(packets, nil, nil, quit, nil, make(chan error, 1), device, CytonDaisy, nil, RecoverDrop)
+	//the first frames are skipped to sync, 4 is without its partner
+	for _, seq := range []int32{5, 7} {
+		select {
+		case p := <-packets:
+			if p.Counts[0] != seq || p.Counts[7] != seq || p.Counts[8] != -(seq+1) || p.Counts[15] != -(seq+1) {
+				t.Error("For frames", seq, seq+1, "expected", seq, -(seq + 1), "got", p.Counts)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for frames", seq, seq+1)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	pb := NewPacketBatcher(2, 16)
+	for i := range pb.packets {
+		p := NewPacket()
+		p.Sample = NewSample(16)
+		for j := range p.Microvolts {
+			p.Microvolts[j] = float64(10*i + j)
+		}
+		pb.packets[i] = p
+	}
+	pb.batch()
+	for j := 0; j < 16; j++ {
+		for i := 0; i < 2; i++ {
+			if res := pb.Chans[chanName(j)][i]; res != float64(10*i+j) {
+				t.Error(
+					"For", chanName(j), "sample", i,
+					"expected", float64(10*i+j),
+					"got", res,
+				)
+			}
+		}
+	}
+}
+
+func TestBatchSample(t *testing.T) {
+	pb := NewPacketBatcher(2, 8)
+	first := pb.sample(1, 8)
+	first.Microvolts[7] = 3
+	if again := pb.sample(1, 8); again != first || pb.packets[1] != first {
+		t.Error("For sample 1 expected the packet reused got", again)
+	}
+	if other := pb.sample(1, 16); other == first || len(other.Microvolts) != 16 {
+		t.Error("For 16 channels expected a new packet got", other)
+	}
+	pb.sample(0, 8).Microvolts[7] = 2
+	pb.sample(1, 8)
+	pb.batch()
+	if res := pb.Chans[chanName(7)]; res[0] != 2 || res[1] != 0 {
+		t.Error("For Chan8 expected [2 0] got", res)
+	}
+}
+
+type testauxpair struct {
+	frame  [33]byte
+	result Packet
+}
+
+var testsaux = []testauxpair{
+	{[33]byte{1: 1, 26: 0, 27: 1, 28: 0, 29: 2, 30: 255, 31: 255, 32: 0xc0},
+		Packet{AuxFormat: AuxAccel, AccX: 1, AccY: 2, AccZ: -1}},
+	{[33]byte{1: 1, 26: 0, 27: 1, 28: 0, 29: 2, 30: 0, 31: 3, 32: 0xc1},
+		Packet{AuxFormat: AuxRaw, Aux: []uint16{1, 2, 3}}},
+	{[33]byte{1: 1, 26: 1, 27: 0, 28: 0, 29: 0, 30: 0, 31: 0, 32: 0xc2},
+		Packet{AuxFormat: AuxUser, Aux: []uint16{256, 0, 0}}},
+	{[33]byte{1: 17, 26: 255, 27: 254, 28: 0, 29: 0, 30: 1, 31: 0, 32: 0xc3},
+		Packet{AuxFormat: AuxTimeSetAccel, AccX: -2, BoardTime: 256}},
+	{[33]byte{1: 19, 26: 0, 27: 5, 28: 0, 29: 0, 30: 0, 31: 9, 32: 0xc4},
+		Packet{AuxFormat: AuxTimeUnsetAccel, AccZ: 5, BoardTime: 9}},
+	{[33]byte{1: 1, 26: 0, 27: 1, 28: 1, 29: 0, 30: 0, 31: 0, 32: 0xc5},
+		Packet{AuxFormat: AuxTimeSetRaw, Aux: []uint16{1}, BoardTime: 1 << 24}},
+	{[33]byte{1: 1, 26: 0, 27: 0, 28: 0, 29: 0, 30: 0, 31: 1, 32: 0xc6},
+		Packet{AuxFormat: AuxTimeUnsetRaw, Aux: []uint16{0}, BoardTime: 1}},
+}
+
+func TestDecodeAux(t *testing.T) {
+	for _, pair := range testsaux {
+		res := NewPacket()
+		decodeAux(res, &pair.frame)
+		exp := pair.result
+		same := res.AuxFormat == exp.AuxFormat && res.AccX == exp.AccX &&
+			res.AccY == exp.AccY && res.AccZ == exp.AccZ &&
+			res.BoardTime == exp.BoardTime && len(res.Aux) == len(exp.Aux)
+		for i := 0; same && i < len(exp.Aux); i++ {
+			same = res.Aux[i] == exp.Aux[i]
+		}
+		if !same {
+			t.Error(
+				"For stop byte", pair.frame[32],
+				"expected", exp.AuxFormat, exp.AccX, exp.AccY, exp.AccZ, exp.Aux, exp.BoardTime,
+				"got", res.AuxFormat, res.AccX, res.AccY, res.AccZ, res.Aux, res.BoardTime,
+			)
+		}
+	}
+}
+
+func TestIsStopByte(t *testing.T) {
+	for b := 0; b < 256; b++ {
+		if res := isStopByte(byte(b)); res != (b >= 0xc0 && b <= 0xc6) {
+			t.Error("For", b, "got", res)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	//scalePSD and scaleDB are the units of the fft stream, µV²/Hz or
+	//decibels relative to 1 µV²/Hz
+	scalePSD = "uv2hz"
+	scaleDB  = "db"
+	//dbFloor stands in for the decibels of no power at all, JSON has no -Inf
+	dbFloor = -200.0
+)
+
+//windows are the tapers a segment can be multiplied with, in the
+//periodic form used for spectral analysis
+var windows = map[string]func(i, n int) float64{
+	"hann": func(i, n int) float64 {
+		return 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n))
+	},
+	"hamming": func(i, n int) float64 {
+		return 0.54 - 0.46*math.Cos(2*math.Pi*float64(i)/float64(n))
+	},
+	"blackman": func(i, n int) float64 {
+		x := 2 * math.Pi * float64(i) / float64(n)
+		return 0.42 - 0.5*math.Cos(x) + 0.08*math.Cos(2*x)
+	},
+}
+
+//PSDConfig is how the fft stream estimates the power spectral density
+//with Welch's method. The FFT window is cut into segments of Segment
+//samples overlapping by the fraction Overlap, every segment is tapered
+//with Window and their periodograms are averaged. A Segment of 0 takes
+//the whole FFT window as a single segment.
+type PSDConfig struct {
+	Window  string
+	Segment int
+	Overlap float64
+	Scale   string
+}
+
+var defaultPSDConfig = PSDConfig{Window: "hann", Overlap: 0.5, Scale: scalePSD}
+
+//validate checks c can be used on FFT windows of size samples
+func (c PSDConfig) validate(size int) error {
+	if _, ok := windows[c.Window]; !ok {
+		return fmt.Errorf("window %q not one of hann, hamming or blackman", c.Window)
+	}
+	if c.Segment != 0 && (c.Segment < 2 || c.Segment > size) {
+		return fmt.Errorf("segment %d out of range 2-%d", c.Segment, size)
+	}
+	if c.Overlap < 0 || c.Overlap >= 1 {
+		return fmt.Errorf("overlap %g out of range [0, 1)", c.Overlap)
+	}
+	if c.Scale != scalePSD && c.Scale != scaleDB {
+		return fmt.Errorf("scale %q not one of %s or %s", c.Scale, scalePSD, scaleDB)
+	}
+	return nil
+}
+
+//segment is the length of the segments of an FFT window of size samples
+func (c PSDConfig) segment(size int) int {
+	if c.Segment == 0 || c.Segment > size {
+		return size
+	}
+	return c.Segment
+}
+
+//welchEstimator estimates the PSD of FFT windows of one size as set up
+//by config. The transform, the window and the segment buffer are made
+//up front so an estimate allocates nothing.
+type welchEstimator struct {
+	config      PSDConfig
+	n           int
+	plan        fourier
+	window      []float64
+	windowPower float64
+	seg         []float64
+}
+
+func newWelchEstimator(c PSDConfig, size int) *welchEstimator {
+	n := c.segment(size)
+	e := &welchEstimator{
+		config: c,
+		n:      n,
+		plan:   newFourier(n),
+		window: make([]float64, n),
+		seg:    make([]float64, n),
+	}
+	for i := range e.window {
+		e.window[i] = windows[c.Window](i, n)
+		e.windowPower += e.window[i] * e.window[i]
+	}
+	return e
+}
+
+//bins is the number of bins in an estimate
+func (e *welchEstimator) bins() int {
+	return e.n / 2
+}
+
+//estimate puts the one-sided PSD of x, sampled at rate, into psd, which
+//holds e.bins() values. Bin k is at k*rate/n Hz for a segment length of
+//n. Every segment has its mean removed before it is tapered.
+func (e *welchEstimator) estimate(x []float64, rate int, psd []float64) {
+	n := e.n
+	step := int(float64(n) * (1 - e.config.Overlap))
+	if step < 1 {
+		step = 1
+	}
+	for k := range psd {
+		psd[k] = 0
+	}
+	var segments int
+	for start := 0; start+n <= len(x); start += step {
+		var mean float64
+		for _, val := range x[start : start+n] {
+			mean += val
+		}
+		mean /= float64(n)
+		for i, val := range x[start : start+n] {
+			e.seg[i] = (val - mean) * e.window[i]
+		}
+		for k, val := range e.plan.transform(e.seg)[:n/2] {
+			psd[k] += real(val)*real(val) + imag(val)*imag(val)
+		}
+		segments++
+	}
+	scale := 1 / (float64(rate) * e.windowPower * float64(segments))
+	for k := range psd {
+		psd[k] *= scale
+		if k > 0 {
+			//fold in the negative frequencies
+			psd[k] *= 2
+		}
+		if e.config.Scale == scaleDB {
+			psd[k] = decibels(psd[k])
+		}
+	}
+}
+
+func (e *welchEstimator) free() {
+	e.plan.free()
+}
+
+func decibels(power float64) float64 {
+	if power <= 0 {
+		return dbFloor
+	}
+	return math.Max(10*math.Log10(power), dbFloor)
+}
+
+//fftConfig is what the /fft/ endpoint sets: the length of the FFT
+//window and the update interval, both in samples, and the estimator
+type fftConfig struct {
+	size, freq int
+	psd        PSDConfig
+}
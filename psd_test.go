@@ -0,0 +1,272 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"math"
+	"math/rand"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+//welch runs a fresh estimator on the whole of x
+func welch(x []float64, rate int, c PSDConfig) []float64 {
+	e := newWelchEstimator(c, len(x))
+	defer e.free()
+	psd := make([]float64, e.bins())
+	e.estimate(x, rate, psd)
+	return psd
+}
+
+func TestWelchSinePower(t *testing.T) {
+	rate := 250
+	x := make([]float64, 500)
+	for i := range x {
+		x[i] = 10 * math.Sin(2*math.Pi*10*float64(i)/float64(rate))
+	}
+	for window := range windows {
+		c := PSDConfig{Window: window, Segment: 250, Overlap: 0.5, Scale: scalePSD}
+		psd := welch(x, rate, c)
+		bins := calcFFTBins(250, rate)
+		var power float64
+		peak := 0
+		for k, p := range psd {
+			power += p * (bins[1] - bins[0])
+			if p > psd[peak] {
+				peak = k
+			}
+		}
+		//a sine of amplitude 10 µV has a power of 50 µV²
+		if len(psd) != 125 || math.Abs(power-50) > 1.5 || bins[peak] != 10 {
+			t.Error("For", window, "expected 50 µV² at 10 Hz got", power, "at", bins[peak])
+		}
+	}
+}
+
+func TestWelchWhiteNoise(t *testing.T) {
+	rate := 250
+	rng := rand.New(rand.NewSource(1))
+	x := make([]float64, 4000)
+	for i := range x {
+		x[i] = 2 * rng.NormFloat64()
+	}
+	c := PSDConfig{Window: "hann", Segment: 200, Overlap: 0.5, Scale: scalePSD}
+	psd := welch(x, rate, c)
+	var mean float64
+	for _, p := range psd[1:] {
+		mean += p / float64(len(psd)-1)
+	}
+	//the variance of 4 µV² spread evenly up to 125 Hz
+	if math.Abs(mean-4.0/125) > 0.1*4.0/125 {
+		t.Error("For white noise of 4 µV² expected", 4.0/125, "µV²/Hz got", mean)
+	}
+	c.Scale = scaleDB
+	db := welch(x, rate, c)
+	for k := range db {
+		if math.Abs(db[k]-10*math.Log10(psd[k])) > 1e-9 {
+			t.Error("For bin", k, "expected", 10*math.Log10(psd[k]), "dB got", db[k])
+		}
+	}
+	if flat := welch(make([]float64, 100), rate, c); flat[3] != dbFloor {
+		t.Error("For a flat line expected", dbFloor, "dB got", flat[3])
+	}
+}
+
+type testpsdconfigpair struct {
+	config PSDConfig
+	ok     bool
+}
+
+var testspsdconfig = []testpsdconfigpair{
+	{defaultPSDConfig, true},
+	{PSDConfig{Window: "blackman", Segment: 128, Overlap: 0.75, Scale: scaleDB}, true},
+	{PSDConfig{Window: "kaiser", Scale: scalePSD}, false},
+	{PSDConfig{Window: "hann", Segment: 512, Scale: scalePSD}, false},
+	{PSDConfig{Window: "hann", Segment: 1, Scale: scalePSD}, false},
+	{PSDConfig{Window: "hann", Overlap: 1, Scale: scalePSD}, false},
+	{PSDConfig{Window: "hann", Scale: "bels"}, false},
+}
+
+func TestPSDConfigValidate(t *testing.T) {
+	for _, pair := range testspsdconfig {
+		if err := pair.config.validate(256); (err == nil) != pair.ok {
+			t.Error("For", pair.config, "expected ok", pair.ok, "got", err)
+		}
+	}
+}
+
+type testffthandlerpair struct {
+	url    string
+	code   int
+	config fftConfig
+}
+
+var testsffthandler = []testffthandlerpair{
+	{"/fft/500&25", 200, fftConfig{500, 25, defaultPSDConfig}},
+	{"/fft/512&50?window=blackman&segment=128&overlap=0.25&scale=db", 200,
+		fftConfig{512, 50, PSDConfig{Window: "blackman", Segment: 128, Overlap: 0.25, Scale: scaleDB}}},
+	{"/fft/512&50?segment=1024", 400, fftConfig{}},
+	{"/fft/512&0", 400, fftConfig{}},
+	{"/fft/512", 400, fftConfig{}},
+}
+
+func TestFFTHandler(t *testing.T) {
+	for _, pair := range testsffthandler {
+		handle := NewHandle(NewMindControl(nil, nil, nil, nil, Cyton, RecoverRepeat))
+		configs := make(chan fftConfig, 1)
+		go func() { configs <- <-handle.mc.deltaFFT }()
+		w := httptest.NewRecorder()
+		handle.fftHandler(w, httptest.NewRequest("POST", pair.url, nil))
+		if w.Code != pair.code {
+			t.Error("For", pair.url, "expected", pair.code, "got", w.Code, w.Body.String())
+			continue
+		}
+		if pair.code == 200 {
+			if c := <-configs; c != pair.config {
+				t.Error("For", pair.url, "expected", pair.config, "got", c)
+			}
+		}
+	}
+}
+
+func TestEstimatorReuse(t *testing.T) {
+	rate := 250
+	rng := rand.New(rand.NewSource(2))
+	x := make([]float64, 256)
+	c := PSDConfig{Window: "hamming", Segment: 64, Overlap: 0.5, Scale: scaleDB}
+	e := newWelchEstimator(c, len(x))
+	defer e.free()
+	psd := make([]float64, e.bins())
+	for round := 0; round < 3; round++ {
+		for i := range x {
+			x[i] = rng.NormFloat64()
+		}
+		e.estimate(x, rate, psd)
+		want := welch(x, rate, c)
+		for k := range want {
+			if psd[k] != want[k] {
+				t.Fatal("For round", round, "bin", k, "expected", want[k], "got", psd[k])
+			}
+		}
+	}
+}
+
+//TestWelchAllocs holds the steady state of the fft stream to no
+//allocations, with both the dB and the linear scale
+func TestWelchAllocs(t *testing.T) {
+	for _, scale := range []string{scalePSD, scaleDB} {
+		for _, size := range []int{256, 1024} {
+			c := defaultPSDConfig
+			c.Scale = scale
+			x := benchmarkSignal(size)
+			e := newWelchEstimator(c, size)
+			psd := make([]float64, e.bins())
+			allocs := testing.AllocsPerRun(100, func() { e.estimate(x, 250, psd) })
+			e.free()
+			if allocs > 0 {
+				t.Error("For", scale, size, "expected 0 allocations got", allocs)
+			}
+		}
+	}
+}
+
+//TestSetPSDAllocs has the band powers, whose spectra are never
+//published, estimated without allocating once the spectra are sized
+func TestSetPSDAllocs(t *testing.T) {
+	pb := NewPacketBatcher(256, 16)
+	defer pb.free()
+	for _, ch := range pb.Chans {
+		copy(ch, benchmarkSignal(256))
+	}
+	pb.setPSD(250, defaultPSDConfig, false)
+	if allocs := testing.AllocsPerRun(100, func() { pb.setPSD(250, defaultPSDConfig, false) }); allocs > 0 {
+		t.Error("For unpublished spectra expected 0 allocations got", allocs)
+	}
+	last := pb.FFTs[chanName(0)]
+	pb.setPSD(250, defaultPSDConfig, true)
+	if &pb.FFTs[chanName(0)][0] == &last[0] {
+		t.Error("For published spectra expected new ones got the last ones written over")
+	}
+}
+
+func benchmarkSizes(b *testing.B, bench func(b *testing.B, size int)) {
+	for _, size := range []int{256, 1024} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) { bench(b, size) })
+	}
+}
+
+func benchmarkSignal(size int) []float64 {
+	rng := rand.New(rand.NewSource(1))
+	x := make([]float64, size)
+	for i := range x {
+		x[i] = rng.NormFloat64()
+	}
+	return x
+}
+
+//BenchmarkWelchCached is the steady state of the fft stream, it should
+//report no allocations
+func BenchmarkWelchCached(b *testing.B) {
+	benchmarkSizes(b, func(b *testing.B, size int) {
+		x := benchmarkSignal(size)
+		e := newWelchEstimator(defaultPSDConfig, size)
+		defer e.free()
+		psd := make([]float64, e.bins())
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			e.estimate(x, 250, psd)
+		}
+	})
+}
+
+//BenchmarkWelchUncached plans for every estimate the way every channel
+//on every tick used to
+func BenchmarkWelchUncached(b *testing.B) {
+	benchmarkSizes(b, func(b *testing.B, size int) {
+		x := benchmarkSignal(size)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			welch(x, 250, defaultPSDConfig)
+		}
+	})
+}
+
+//BenchmarkSetPSD is one fft tick of a daisy board, only the published
+//spectra are allocated
+func BenchmarkSetPSD(b *testing.B) {
+	for _, published := range []bool{true, false} {
+		b.Run("published="+strconv.FormatBool(published), func(b *testing.B) {
+			benchmarkSizes(b, func(b *testing.B, size int) {
+				pb := NewPacketBatcher(size, 16)
+				for _, ch := range pb.Chans {
+					copy(ch, benchmarkSignal(size))
+				}
+				defer pb.free()
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					pb.setPSD(250, defaultPSDConfig, published)
+				}
+			})
+		})
+	}
+}
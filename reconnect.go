@@ -0,0 +1,193 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kevinjos/openbci-driver"
+)
+
+const (
+	reconnectMinBackoff = 500 * time.Millisecond
+	reconnectMaxBackoff = 30 * time.Second
+)
+
+var errDeviceDisconnected = errors.New("device disconnected")
+
+//DeviceOpener (re)opens the device the server streams from
+type DeviceOpener func() (io.ReadWriteCloser, error)
+
+//swappableDevice keeps one handle for the handlers and the decoder while
+//the device underneath is replaced after an I/O error. While a capture is
+//set every chunk read and written is recorded to it.
+type swappableDevice struct {
+	mu      sync.RWMutex
+	device  io.ReadWriteCloser
+	capture *Capture
+}
+
+func newSwappableDevice(device io.ReadWriteCloser) *swappableDevice {
+	return &swappableDevice{device: device}
+}
+
+func (s *swappableDevice) Read(p []byte) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.device == nil {
+		return 0, errDeviceDisconnected
+	}
+	n, err := s.device.Read(p)
+	if n > 0 && s.capture != nil {
+		s.capture.record(captureRead, p[:n])
+	}
+	return n, err
+}
+
+func (s *swappableDevice) Write(p []byte) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.device == nil {
+		return 0, errDeviceDisconnected
+	}
+	n, err := s.device.Write(p)
+	if n > 0 && s.capture != nil {
+		s.capture.record(captureWrite, p[:n])
+	}
+	return n, err
+}
+
+func (s *swappableDevice) Close() error {
+	return s.swap(nil)
+}
+
+//swap closes the current device and replaces it, nil marks it disconnected
+func (s *swappableDevice) swap(device io.ReadWriteCloser) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	if s.device != nil {
+		err = s.device.Close()
+	}
+	s.device = device
+	return err
+}
+
+//connected is false while the device is being reopened
+func (s *swappableDevice) connected() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.device != nil
+}
+
+//setCapture starts recording to capture, nil stops it; the capture
+//being replaced is handed back
+func (s *swappableDevice) setCapture(capture *Capture) *Capture {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.capture
+	s.capture = capture
+	return old
+}
+
+//replace puts device in place of the current one and hands the old one
+//back without closing it
+func (s *swappableDevice) replace(device io.ReadWriteCloser) io.ReadWriteCloser {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.device
+	s.device = device
+	return old
+}
+
+//superviseDevice waits for the decoder to report a device error, tells
+//the clients and reopens the device with exponential backoff. Once the
+//device is back decoding is restarted and, if the board was streaming,
+//so is the stream. The websocket sessions are left untouched.
+func (mc *MindControl) superviseDevice() {
+	for {
+		select {
+		case <-mc.quitDecodeStream:
+			return
+		case err := <-mc.deviceErr:
+			glog.Errorf("error reading from device: %s\n", err)
+			mc.broadcastStatus("device error: "+err.Error(), false, 0)
+			mc.device.swap(nil)
+			if mc.reopen == nil {
+				continue
+			}
+			if !mc.reconnect() {
+				return
+			}
+			//keep the gains set on the board, the decoder would otherwise
+			//fall back to the defaults
+			mc.mu.Lock()
+			board, gains, streaming := mc.board, append([]float64(nil), mc.gain...), mc.streaming
+			mc.mu.Unlock()
+			go DecodeStream(mc.PacketChan, mc.gainC, mc.boardC, mc.quitDecodeStream, mc.pauseRead, mc.deviceErr,
+				mc.SerialDevice, board, gains, mc.recovery)
+			//decoding runs again before anything is queued, commands that
+			//pause it would otherwise wait on a decoder that is not there
+			if streaming {
+				mc.writeCommand(string(openbci.Command["start"]))
+			}
+		}
+	}
+}
+
+//reconnect retries mc.reopen until it succeeds or MindControl is closed
+func (mc *MindControl) reconnect() bool {
+	backoff := reconnectMinBackoff
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-mc.quitDecodeStream:
+			return false
+		case <-time.After(backoff):
+		}
+		device, err := mc.reopen()
+		if err == nil {
+			mc.device.swap(device)
+			glog.Infof("device reconnected after %d attempts\n", attempt)
+			mc.broadcastStatus("device reconnected", true, attempt)
+			return true
+		}
+		glog.Errorf("error reopening device: %s\n", err)
+		mc.broadcastStatus("reconnecting: "+err.Error(), false, attempt)
+		backoff *= 2
+		if backoff > reconnectMaxBackoff {
+			backoff = reconnectMaxBackoff
+		}
+	}
+}
+
+func (mc *MindControl) broadcastStatus(status string, connected bool, attempt int) {
+	var c float64
+	if connected {
+		c = 1
+	}
+	glog.V(1).Infoln(status)
+	mc.broadcast <- newStatusMessage(status, map[string][]float64{
+		"connected": []float64{c},
+		"attempt":   []float64{float64(attempt)},
+	})
+}
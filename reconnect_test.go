@@ -0,0 +1,208 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//testDevice plays back stream and then fails every read with err
+type testDevice struct {
+	r       io.Reader
+	err     error
+	mu      sync.Mutex
+	written bytes.Buffer
+}
+
+func (d *testDevice) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err == io.EOF && d.err != nil {
+		return n, d.err
+	}
+	return n, err
+}
+
+func (d *testDevice) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.written.Write(p)
+}
+
+func (d *testDevice) Close() error { return nil }
+
+//writes is written for tests that read it while the device is in use
+func (d *testDevice) writes() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.written.String()
+}
+
+//waitWritten waits for want to show up in the writes to d
+func waitWritten(t *testing.T, d *testDevice, want string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(d.writes(), want) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected", want, "written, got", d.writes())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	unplugged := &testDevice{r: bytes.NewReader(nil), err: errors.New("unplugged")}
+	replugged := &testDevice{r: bytes.NewReader(testStream(8))}
+	attempts := 0
+	reopen := func() (io.ReadWriteCloser, error) {
+		attempts++
+		if attempts < 2 {
+			return nil, errors.New("no such device")
+		}
+		return replugged, nil
+	}
+	broadcast := make(chan *message, 8)
+	mc := NewMindControl(broadcast, make(chan bool, 1), unplugged, reopen, Cyton, RecoverRepeat)
+	mc.streaming = true
+	go DecodeStream(mc.PacketChan, mc.gainC, mc.boardC, mc.quitDecodeStream, mc.pauseRead, mc.deviceErr,
+		mc.SerialDevice, mc.board, nil, mc.recovery)
+	go mc.runCommands()
+	go mc.superviseDevice()
+	defer close(mc.quitDecodeStream)
+
+	var statuses []string
+	for len(statuses) < 3 {
+		select {
+		case m := <-broadcast:
+			statuses = append(statuses, m.Status)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for status, got", statuses)
+		}
+	}
+	expected := []string{"device error: unplugged", "reconnecting: no such device", "device reconnected"}
+	for i := range expected {
+		if statuses[i] != expected[i] {
+			t.Error("For status", i, "expected", expected[i], "got", statuses[i])
+		}
+	}
+	select {
+	case p := <-mc.PacketChan:
+		if p.seqNum != 3 {
+			t.Error("For first packet after reconnect expected seq 3 got", p.seqNum)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for packets after reconnect")
+	}
+	waitWritten(t, replugged, "b")
+}
+
+func TestReconnectKeepsGains(t *testing.T) {
+	unplugged := &testDevice{r: bytes.NewReader(nil), err: errors.New("unplugged")}
+	replugged := &testDevice{r: bytes.NewReader(testStream(8))}
+	reopen := func() (io.ReadWriteCloser, error) { return replugged, nil }
+	mc := NewMindControl(make(chan *message, 8), make(chan bool, 1), unplugged, reopen, Cyton, RecoverRepeat)
+	//as left by /x/, QueryConfig or ApplyChannelSettings before the device went
+	mc.gain = Cyton.newGains()
+	mc.gain[0] = 1
+	go DecodeStream(mc.PacketChan, mc.gainC, mc.boardC, mc.quitDecodeStream, mc.pauseRead, mc.deviceErr,
+		mc.SerialDevice, mc.board, nil, mc.recovery)
+	go mc.runCommands()
+	go mc.superviseDevice()
+	defer close(mc.quitDecodeStream)
+
+	select {
+	case p := <-mc.PacketChan:
+		for ch, gain := range mc.gain {
+			if uv := scaleToMicroVolts(p.Counts[ch], gain); p.Microvolts[ch] != uv {
+				t.Error("For channel", ch+1, "after reconnect expected", uv, "got", p.Microvolts[ch])
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for packets after reconnect")
+	}
+}
+
+//TestReconnectWithPauseQueued has a command that pauses the decoder
+//queued while the device is gone, it must not keep the stream from being
+//restarted once the device is back
+func TestReconnectWithPauseQueued(t *testing.T) {
+	unplugged := &testDevice{r: bytes.NewReader(nil), err: errors.New("unplugged")}
+	replugged := &testDevice{r: bytes.NewReader(testStream(8))}
+	reopen := func() (io.ReadWriteCloser, error) { return replugged, nil }
+	mc := NewMindControl(make(chan *message, 8), make(chan bool, 1), unplugged, reopen, Cyton, RecoverRepeat)
+	mc.streaming = true
+	go DecodeStream(mc.PacketChan, mc.gainC, mc.boardC, mc.quitDecodeStream, mc.pauseRead, mc.deviceErr,
+		mc.SerialDevice, mc.board, nil, mc.recovery)
+	go mc.runCommands()
+	go mc.superviseDevice()
+	defer close(mc.quitDecodeStream)
+	go mc.Execute(Command{Bytes: "?", Pause: true})
+
+	select {
+	case <-mc.PacketChan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for packets after reconnect")
+	}
+	waitWritten(t, replugged, "b")
+}
+
+//TestReconnectWhileHandling toggles the stream and changes the channel
+//settings while the device is reopened, run it with -race
+func TestReconnectWhileHandling(t *testing.T) {
+	unplugged := &testDevice{r: bytes.NewReader(nil), err: errors.New("unplugged")}
+	replugged := &testDevice{r: bytes.NewReader(testStream(8))}
+	reopen := func() (io.ReadWriteCloser, error) { return replugged, nil }
+	mc := NewMindControl(make(chan *message, 64), make(chan bool, 1), unplugged, reopen, Cyton, RecoverRepeat)
+	handle := NewHandle(mc)
+	go DecodeStream(mc.PacketChan, mc.gainC, mc.boardC, mc.quitDecodeStream, mc.pauseRead, mc.deviceErr,
+		mc.SerialDevice, mc.board, nil, mc.recovery)
+	go mc.runCommands()
+	go mc.superviseDevice()
+	defer close(mc.quitDecodeStream)
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 10; i++ {
+			handle.startHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/start", nil))
+			handle.stopHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/stop", nil))
+		}
+		done <- true
+	}()
+	var err error
+	go func() {
+		_, err = mc.ApplyChannelSettings([]ChannelSettings{{Channel: 1, Gain: 2, InputType: "normal", Bias: true, SRB2: true}})
+		done <- true
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for the handlers")
+		}
+	}
+	//written while unplugged the settings are refused and left alone
+	if s := mc.ChannelSettings()[0]; (err == nil) != (s.Gain == 2) {
+		t.Error("For channel 1 expected gain 2 only once written got", s.Gain, err)
+	}
+}
@@ -0,0 +1,120 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+//RecoveryPolicy selects how samples lost to a sequence gap are replaced
+type RecoveryPolicy uint8
+
+const (
+	RecoverRepeat      RecoveryPolicy = iota //repeat the last sample
+	RecoverInterpolate                       //linear between the neighbours
+	RecoverZero                              //zero filled
+	RecoverNaN                               //NaN microvolts, zero counts
+	RecoverDrop                              //synthesize nothing
+)
+
+var recoveryPolicies = map[string]RecoveryPolicy{
+	"repeat":      RecoverRepeat,
+	"interpolate": RecoverInterpolate,
+	"zero":        RecoverZero,
+	"nan":         RecoverNaN,
+	"drop":        RecoverDrop,
+}
+
+//ParseRecoveryPolicy looks a policy up by its name
+func ParseRecoveryPolicy(name string) (RecoveryPolicy, error) {
+	policy, ok := recoveryPolicies[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown recovery policy %q", name)
+	}
+	return policy, nil
+}
+
+//gapRecoverer watches the sequence numbers of decoded packets and
+//synthesizes the samples missing between two of them
+type gapRecoverer struct {
+	policy RecoveryPolicy
+	step   uint8
+	last   *Packet
+}
+
+func newGapRecoverer(policy RecoveryPolicy, board Board) *gapRecoverer {
+	var step uint8 = 1
+	if board.Daisy {
+		step = 2
+	}
+	return &gapRecoverer{
+		policy: policy,
+		step:   step,
+	}
+}
+
+//recover returns the samples synthesized for the gap in front of p,
+//if any, followed by p itself. p.Lost is set to the size of the gap.
+func (g *gapRecoverer) recover(p *Packet) []*Packet {
+	var lost uint8
+	if g.last != nil {
+		if diff := difference(p.seqNum, g.last.seqNum); diff > g.step {
+			lost = diff/g.step - 1
+		}
+	}
+	out := make([]*Packet, 0, int(lost)+1)
+	if g.policy != RecoverDrop {
+		for i := uint8(1); i <= lost; i++ {
+			out = append(out, g.synthesize(p, i, lost))
+		}
+	}
+	p.Lost = lost
+	//keep a copy, downstream filters the microvolts in place
+	last := *p
+	last.Sample = p.Sample.Copy()
+	g.last = &last
+	return append(out, p)
+}
+
+//synthesize makes up the i-th of n samples lost between the last
+//packet and next
+func (g *gapRecoverer) synthesize(next *Packet, i, n uint8) *Packet {
+	p := *g.last
+	p.seqNum = g.last.seqNum + i*g.step
+	p.Sample = NewSample(len(g.last.Microvolts))
+	p.SignalQuality = 100 - (n+1)*g.step
+	p.Synced = false
+	p.Synthesized = true
+	p.Lost = n
+	frac := float64(i) / float64(n+1)
+	for ch := range p.Microvolts {
+		switch g.policy {
+		case RecoverRepeat:
+			p.Microvolts[ch] = g.last.Microvolts[ch]
+			p.Counts[ch] = g.last.Counts[ch]
+		case RecoverInterpolate:
+			p.Microvolts[ch] = g.last.Microvolts[ch] + frac*(next.Microvolts[ch]-g.last.Microvolts[ch])
+			p.Counts[ch] = g.last.Counts[ch] + int32(math.Round(frac*float64(next.Counts[ch]-g.last.Counts[ch])))
+		case RecoverNaN:
+			p.Microvolts[ch] = math.NaN()
+		}
+	}
+	return &p
+}
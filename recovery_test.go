@@ -0,0 +1,88 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func testPacket(seq byte, val float64) *Packet {
+	p := NewPacket()
+	p.seqNum = seq
+	p.Sample = NewSample(1)
+	p.Microvolts[0] = val
+	p.Counts[0] = int32(val)
+	return p
+}
+
+type testrecoverypair struct {
+	policy RecoveryPolicy
+	board  Board
+	seqs   []byte
+	result []float64
+}
+
+var testsrecovery = []testrecoverypair{
+	{RecoverRepeat, Cyton, []byte{1, 2}, []float64{0, 1}},
+	{RecoverRepeat, Cyton, []byte{254, 2}, []float64{0, 0, 0, 0, 1}},
+	{RecoverInterpolate, Cyton, []byte{1, 5}, []float64{0, 0.25, 0.5, 0.75, 1}},
+	{RecoverInterpolate, CytonDaisy, []byte{2, 6}, []float64{0, 0.5, 1}},
+	{RecoverZero, Cyton, []byte{1, 3}, []float64{0, 0, 1}},
+	{RecoverNaN, Cyton, []byte{1, 3}, []float64{0, math.NaN(), 1}},
+	{RecoverDrop, Cyton, []byte{1, 5}, []float64{0, 1}},
+}
+
+func TestGapRecoverer(t *testing.T) {
+	for _, pair := range testsrecovery {
+		g := newGapRecoverer(pair.policy, pair.board)
+		var res []float64
+		for i, seq := range pair.seqs {
+			for _, p := range g.recover(testPacket(seq, float64(i))) {
+				res = append(res, p.Microvolts[0])
+			}
+		}
+		same := len(res) == len(pair.result)
+		for i := 0; same && i < len(res); i++ {
+			same = res[i] == pair.result[i] || math.IsNaN(res[i]) && math.IsNaN(pair.result[i])
+		}
+		if !same {
+			t.Error(
+				"For policy", pair.policy, "and seqs", pair.seqs,
+				"expected", pair.result,
+				"got", res,
+			)
+		}
+	}
+}
+
+func TestGapRecovererFlags(t *testing.T) {
+	g := newGapRecoverer(RecoverZero, Cyton)
+	g.recover(testPacket(10, 0))
+	out := g.recover(testPacket(13, 1))
+	for i, p := range out {
+		if p.Lost != 2 || p.Synthesized != (i < 2) {
+			t.Error(
+				"For sample", i,
+				"expected Lost 2 and Synthesized", i < 2,
+				"got", p.Lost, "and", p.Synthesized,
+			)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//queryRegisters asks the board to print its register settings
+const queryRegisters = '?'
+
+const registerTimeout = 2 * time.Second
+
+var errStreaming = errors.New("stop the stream before querying the board")
+
+//gainCodes are the gains selected by bits 6:4 of a CHnSET register
+var gainCodes = []int{1, 2, 4, 6, 8, 12, 24}
+
+//inputTypes are the inputs selected by bits 2:0 of a CHnSET register
+var inputTypes = []string{"normal", "shorted", "bias_meas", "mvdd", "temp", "testsig", "bias_drp", "bias_drn"}
+
+//ChannelSettings is the state of one ADS1299 channel
+type ChannelSettings struct {
+	Channel   int
+	PowerDown bool
+	Gain      int
+	InputType string
+	Bias      bool
+	SRB2      bool
+	SRB1      bool
+}
+
+//command encodes the settings as the x...X command that applies them
+func (c ChannelSettings) command() string {
+	flag := func(b bool) string {
+		if b {
+			return "1"
+		}
+		return "0"
+	}
+	gain, input := 0, 0
+	for i, g := range gainCodes {
+		if g == c.Gain {
+			gain = i
+		}
+	}
+	for i, t := range inputTypes {
+		if t == c.InputType {
+			input = i
+		}
+	}
+	return "x" + channelIDs[c.Channel-1:c.Channel] + flag(c.PowerDown) + strconv.Itoa(gain) +
+		strconv.Itoa(input) + flag(c.Bias) + flag(c.SRB2) + flag(c.SRB1) + "X"
+}
+
+//BoardConfig is the board state read back from the register dump, one
+//register map per ADS1299, the daisy's second
+type BoardConfig struct {
+	Registers []map[string]byte
+	Channels  []ChannelSettings
+}
+
+//Gains returns the gain of every channel as used to scale the samples
+func (c *BoardConfig) Gains() []float64 {
+	gains := make([]float64, len(c.Channels))
+	for i, ch := range c.Channels {
+		gains[i] = float64(ch.Gain)
+	}
+	return gains
+}
+
+//parseRegisters parses the response to the ? command. Register lines
+//read "NAME, ADDR, VALUE, bit7, ..., bit0" with hex address and value;
+//the ADS sections are headed "Board ADS Registers" and "Daisy ADS
+//Registers", other sections such as the LIS3DH's are skipped.
+func parseRegisters(dump string) (*BoardConfig, error) {
+	config := &BoardConfig{}
+	var ads map[string]byte
+	for _, line := range strings.Split(dump, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasSuffix(line, "ADS Registers"):
+			ads = make(map[string]byte)
+			config.Registers = append(config.Registers, ads)
+			continue
+		case strings.HasSuffix(line, "Registers"):
+			ads = nil
+			continue
+		case ads == nil || line == "":
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) < 3 {
+			continue
+		}
+		val, err := strconv.ParseUint(strings.TrimSpace(fields[2]), 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("bad register line %q: %s", line, err)
+		}
+		ads[strings.TrimSpace(fields[0])] = byte(val)
+	}
+	if len(config.Registers) == 0 {
+		return nil, errors.New("no ADS registers in response")
+	}
+	for chip, regs := range config.Registers {
+		misc1, ok := regs["MISC1"]
+		if !ok {
+			return nil, errors.New("missing MISC1 register")
+		}
+		for i := 0; i < 8; i++ {
+			chset, ok := regs["CH"+strconv.Itoa(i+1)+"SET"]
+			if !ok {
+				return nil, fmt.Errorf("missing CH%dSET register", i+1)
+			}
+			gain := int(chset>>4) & 7
+			if gain >= len(gainCodes) {
+				return nil, fmt.Errorf("reserved gain code in CH%dSET", i+1)
+			}
+			config.Channels = append(config.Channels, ChannelSettings{
+				Channel:   chip*8 + i + 1,
+				PowerDown: chset&0x80 != 0,
+				Gain:      gainCodes[gain],
+				InputType: inputTypes[chset&7],
+				Bias:      regs["BIAS_SENSP"]&(1<<uint(i)) != 0,
+				SRB2:      chset&0x08 != 0,
+				SRB1:      misc1&0x20 != 0,
+			})
+		}
+	}
+	return config, nil
+}
+
+//QueryConfig reads the register settings back from the board and feeds
+//the channel gains to the decoder. The board only answers while it is
+//not streaming.
+func (mc *MindControl) QueryConfig() (*BoardConfig, error) {
+	if mc.isStreaming() {
+		return nil, errStreaming
+	}
+	dump, err := mc.Execute(Command{
+		Bytes:    string(queryRegisters),
+		Pause:    true,
+		Response: true,
+		Timeout:  registerTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	config, err := parseRegisters(dump)
+	if err != nil {
+		return nil, err
+	}
+	mc.mu.Lock()
+	if len(config.Channels) != mc.board.Channels {
+		defer mc.mu.Unlock()
+		return nil, fmt.Errorf("board reports %d channels, expected %d", len(config.Channels), mc.board.Channels)
+	}
+	mc.config = config
+	mc.gain = config.Gains()
+	defer mc.mu.Unlock()
+	err = mc.sendGains(config.Gains())
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
@@ -0,0 +1,121 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const testRegisterDump = `
+Board ADS Registers
+ADS_ID, 00, 3E, 0, 0, 1, 1, 1, 1, 1, 0
+CONFIG1, 01, 96, 1, 0, 0, 1, 0, 1, 1, 0
+CONFIG2, 02, C0, 1, 1, 0, 0, 0, 0, 0, 0
+CONFIG3, 03, EC, 1, 1, 1, 0, 1, 1, 0, 0
+LOFF, 04, 02, 0, 0, 0, 0, 0, 0, 1, 0
+CH1SET, 05, 68, 0, 1, 1, 0, 1, 0, 0, 0
+CH2SET, 06, 60, 0, 1, 1, 0, 0, 0, 0, 0
+CH3SET, 07, E8, 1, 1, 1, 0, 1, 0, 0, 0
+CH4SET, 08, 01, 0, 0, 0, 0, 0, 0, 0, 1
+CH5SET, 09, 15, 0, 0, 0, 1, 0, 1, 0, 1
+CH6SET, 0A, 68, 0, 1, 1, 0, 1, 0, 0, 0
+CH7SET, 0B, 68, 0, 1, 1, 0, 1, 0, 0, 0
+CH8SET, 0C, 68, 0, 1, 1, 0, 1, 0, 0, 0
+BIAS_SENSP, 0D, FD, 1, 1, 1, 1, 1, 1, 0, 1
+BIAS_SENSN, 0E, FF, 1, 1, 1, 1, 1, 1, 1, 1
+LOFF_SENSP, 0F, 00, 0, 0, 0, 0, 0, 0, 0, 0
+LOFF_SENSN, 10, 00, 0, 0, 0, 0, 0, 0, 0, 0
+LOFF_FLIP, 11, 00, 0, 0, 0, 0, 0, 0, 0, 0
+LOFF_STATP, 12, 00, 0, 0, 0, 0, 0, 0, 0, 0
+LOFF_STATN, 13, FF, 1, 1, 1, 1, 1, 1, 1, 1
+GPIO, 14, 0F, 0, 0, 0, 0, 1, 1, 1, 1
+MISC1, 15, 20, 0, 0, 1, 0, 0, 0, 0, 0
+MISC2, 16, 00, 0, 0, 0, 0, 0, 0, 0, 0
+CONFIG4, 17, 00, 0, 0, 0, 0, 0, 0, 0, 0
+
+LIS3DH Registers
+0x07.0
+0x08.0
+`
+
+type testregisterpair struct {
+	channel int
+	result  ChannelSettings
+}
+
+var testsregisters = []testregisterpair{
+	{0, ChannelSettings{Channel: 1, Gain: 24, InputType: "normal", Bias: true, SRB2: true, SRB1: true}},
+	{1, ChannelSettings{Channel: 2, Gain: 24, InputType: "normal", SRB1: true}},
+	{2, ChannelSettings{Channel: 3, PowerDown: true, Gain: 24, InputType: "normal", Bias: true, SRB2: true, SRB1: true}},
+	{3, ChannelSettings{Channel: 4, Gain: 1, InputType: "shorted", Bias: true, SRB1: true}},
+	{4, ChannelSettings{Channel: 5, Gain: 2, InputType: "testsig", Bias: true, SRB1: true}},
+}
+
+func TestParseRegisters(t *testing.T) {
+	config, err := parseRegisters(testRegisterDump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Channels) != 8 || config.Registers[0]["CONFIG3"] != 0xEC {
+		t.Fatal("For board registers expected 8 channels and CONFIG3 EC got", len(config.Channels), config.Registers[0]["CONFIG3"])
+	}
+	for _, pair := range testsregisters {
+		if res := config.Channels[pair.channel]; res != pair.result {
+			t.Error(
+				"For channel", pair.channel+1,
+				"expected", pair.result,
+				"got", res,
+			)
+		}
+	}
+	daisy := testRegisterDump + strings.Replace(testRegisterDump, "Board", "Daisy", 1)
+	config, err = parseRegisters(daisy)
+	if err != nil || len(config.Channels) != 16 || config.Channels[11].Channel != 12 || config.Channels[11].Gain != 1 {
+		t.Error("For daisy registers expected 16 channels, channel 12 at gain 1, got", err, config)
+	}
+	if _, err = parseRegisters("Failure: not streaming"); err == nil {
+		t.Error("For a response without registers expected an error")
+	}
+}
+
+func TestQueryConfig(t *testing.T) {
+	device := &testDevice{r: strings.NewReader(testRegisterDump + responseEnd)}
+	mc := NewMindControl(nil, nil, device, nil, Cyton, RecoverRepeat)
+	go mc.runCommands()
+	defer close(mc.quitDecodeStream)
+	gains := make(chan []float64, 1)
+	go func() {
+		resume := <-mc.pauseRead
+		<-resume
+		gains <- <-mc.gainC
+	}()
+	config, err := mc.QueryConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := <-gains
+	if device.written.String() != "?" || len(config.Channels) != 8 || g[3] != 1 || mc.gain[4] != 2 {
+		t.Error("expected ? written and gains from the registers, got", device.written.String(), g, mc.gain)
+	}
+	mc.streaming = true
+	if _, err = mc.QueryConfig(); err != errStreaming {
+		t.Error("For a streaming board expected", errStreaming, "got", err)
+	}
+}
@@ -0,0 +1,340 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kevinjos/eeg-web-server/int24"
+	"github.com/kevinjos/openbci-driver"
+)
+
+//Recording is an EDF or BDF file read into memory, one slice of
+//microvolts per signal
+type Recording struct {
+	Labels           []string
+	SamplesPerSecond float64
+	Signals          [][]float64
+}
+
+//OpenRecording reads the EDF or BDF file at path
+func OpenRecording(path string) (*Recording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readRecording(f)
+}
+
+//readRecording parses an EDF or BDF file. Signals sampled at a different
+//rate than the first one, such as annotations, are skipped.
+func readRecording(r io.Reader) (*Recording, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 256 {
+		return nil, errors.New("recording too short for a header")
+	}
+	field := func(off, n int) string {
+		return strings.TrimSpace(string(buf[off : off+n]))
+	}
+	width := 2
+	if buf[0] == 0xff && field(1, 7) == "BIOSEMI" {
+		width = 3
+	}
+	ns, err := strconv.Atoi(field(252, 4))
+	if err != nil || ns <= 0 {
+		return nil, fmt.Errorf("bad number of signals %q", field(252, 4))
+	}
+	if len(buf) < 256+256*ns {
+		return nil, errors.New("recording too short for its signal headers")
+	}
+	duration, err := strconv.ParseFloat(field(244, 8), 64)
+	if err != nil || duration <= 0 {
+		return nil, fmt.Errorf("bad data record duration %q", field(244, 8))
+	}
+	//the signal header lists each field for all signals in turn
+	signalField := func(off, n, i int) string {
+		return field(256+off*ns+n*i, n)
+	}
+	var (
+		rec       = &Recording{}
+		keep      []int
+		gains     []float64
+		offsets   []float64
+		perRecord = make([]int, ns)
+		recordLen int
+	)
+	for i := 0; i < ns; i++ {
+		perRecord[i], err = strconv.Atoi(signalField(216, 8, i))
+		if err != nil {
+			return nil, fmt.Errorf("bad number of samples for signal %d: %s", i+1, err)
+		}
+		recordLen += perRecord[i] * width
+		label := signalField(0, 16, i)
+		if strings.HasSuffix(label, "Annotations") || len(keep) > 0 && perRecord[i] != perRecord[keep[0]] {
+			glog.Infof("Skipping signal %d of the recording, it has a different rate\n", i+1)
+			continue
+		}
+		var vals [4]float64
+		for j, off := range []int{104, 112, 120, 128} {
+			vals[j], err = strconv.ParseFloat(signalField(off, 8, i), 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad range for signal %d: %s", i+1, err)
+			}
+		}
+		phymin, phymax, digmin, digmax := vals[0], vals[1], vals[2], vals[3]
+		if digmax == digmin {
+			return nil, fmt.Errorf("empty digital range for signal %d", i+1)
+		}
+		unit := microvoltsPer(signalField(96, 8, i))
+		keep = append(keep, i)
+		gains = append(gains, (phymax-phymin)/(digmax-digmin)*unit)
+		offsets = append(offsets, (phymin-digmin*(phymax-phymin)/(digmax-digmin))*unit)
+		rec.Labels = append(rec.Labels, label)
+	}
+	if len(keep) == 0 || perRecord[keep[0]] == 0 {
+		return nil, errors.New("recording has no samples")
+	}
+	data := buf[256+256*ns:]
+	records := len(data) / recordLen
+	rec.SamplesPerSecond = float64(perRecord[keep[0]]) / duration
+	rec.Signals = make([][]float64, len(keep))
+	for r := 0; r < records; r++ {
+		off := r * recordLen
+		k := 0
+		for i := 0; i < ns; i++ {
+			n := perRecord[i]
+			if k < len(keep) && keep[k] == i {
+				for s := 0; s < n; s++ {
+					b := data[off+s*width : off+(s+1)*width]
+					var dig int32
+					if width == 3 {
+						dig = int24.UnmarshalSLE(b)
+					} else {
+						dig = int32(int16(uint16(b[0]) | uint16(b[1])<<8))
+					}
+					rec.Signals[k] = append(rec.Signals[k], float64(dig)*gains[k]+offsets[k])
+				}
+				k++
+			}
+			off += n * width
+		}
+	}
+	return rec, nil
+}
+
+//microvoltsPer is the number of microvolts in a physical dimension
+func microvoltsPer(dimension string) float64 {
+	switch strings.ToLower(dimension) {
+	case "mv":
+		return 1e3
+	case "v":
+		return 1e6
+	}
+	return 1
+}
+
+//Board is the board the recording is played back as, the smallest that
+//has a channel for every signal
+func (rec *Recording) Board() (Board, error) {
+	board := Cyton
+	if len(rec.Signals) > Cyton.Channels {
+		board = CytonDaisy
+	}
+	if len(rec.Signals) > board.Channels {
+		return board, fmt.Errorf("%d signals do not fit on a board", len(rec.Signals))
+	}
+	board.SamplesPerSecond = int(math.Round(rec.SamplesPerSecond))
+	return board, nil
+}
+
+//Duration is the length of the recording
+func (rec *Recording) Duration() time.Duration {
+	return time.Duration(float64(len(rec.Signals[0])) / rec.SamplesPerSecond * float64(time.Second))
+}
+
+//ReplayDevice plays a recording back as a board would stream it. The
+//samples are encoded into frames with the gains set on the device and
+//paced at the recording's rate times the speed. Apart from start and
+//stop it takes the commands the generator does; the sample rate command
+//is ignored as the rate is the recording's.
+type ReplayDevice struct {
+	pacedStream
+	firmware
+	recording *Recording
+	speed     float64
+	loop      bool
+	playing   bool
+	paused    bool
+	pos       int
+	seq       byte
+	closeOnce sync.Once
+}
+
+//ReplayState is where a replay is at
+type ReplayState struct {
+	Position float64
+	Duration float64
+	Paused   bool
+	Loop     bool
+	Speed    float64
+}
+
+//NewReplayDevice returns a device playing rec as board
+func NewReplayDevice(rec *Recording, board Board, speed float64, loop bool) *ReplayDevice {
+	return &ReplayDevice{
+		pacedStream: newPacedStream(rec.SamplesPerSecond*speed, make(chan bool)),
+		firmware:    newFirmware(board),
+		recording:   rec,
+		speed:       speed,
+		loop:        loop,
+	}
+}
+
+//Run produces frames until the device is closed
+func (r *ReplayDevice) Run() {
+	r.run(r.frames)
+}
+
+//update starts or stops the pacing to match playing and paused, mu must
+//be held
+func (r *ReplayDevice) update() {
+	streaming := r.playing && !r.paused
+	if streaming && !r.streaming {
+		r.restart()
+	}
+	r.streaming = streaming
+}
+
+//frames returns the frames of the next sample of the recording
+func (r *ReplayDevice) frames() []byte {
+	if r.pos >= len(r.recording.Signals[0]) {
+		if !r.loop {
+			glog.Infoln("Replay reached the end of the recording")
+			r.paused = true
+			r.update()
+			return nil
+		}
+		r.pos = 0
+	}
+	counts := make([]int32, len(r.settings))
+	for ch, signal := range r.recording.Signals {
+		if !r.settings[ch].PowerDown {
+			counts[ch] = countsFromMicroVolts(signal[r.pos], float64(r.settings[ch].Gain))
+		}
+	}
+	r.pos++
+	var out []byte
+	for _, frame := range sampleFrames(&r.seq, counts) {
+		out = append(out, frame[:]...)
+	}
+	return out
+}
+
+//Write takes commands the way the firmware does, one byte at a time
+func (r *ReplayDevice) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, b := range p {
+		switch {
+		case r.idle() && b == openbci.Command["start"]:
+			r.playing = true
+			r.update()
+		case r.idle() && b == openbci.Command["stop"]:
+			r.playing = false
+			r.update()
+		default:
+			text, _ := r.write(b)
+			r.text = append(r.text, text...)
+		}
+	}
+	return len(p), nil
+}
+
+//Close stops the device for good
+func (r *ReplayDevice) Close() error {
+	r.closeOnce.Do(func() { close(r.quit) })
+	return nil
+}
+
+//Pause holds the replay at its position until it is resumed
+func (r *ReplayDevice) Pause(paused bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.paused = paused
+	r.update()
+}
+
+//Seek moves the replay to offset from the start of the recording
+func (r *ReplayDevice) Seek(offset time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pos := int(offset.Seconds() * r.recording.SamplesPerSecond)
+	if pos < 0 || pos > len(r.recording.Signals[0]) {
+		return fmt.Errorf("seek to %s outside the recording's %s", offset, r.recording.Duration())
+	}
+	r.pos = pos
+	return nil
+}
+
+//SetLoop sets whether the replay starts over at the end of the recording
+func (r *ReplayDevice) SetLoop(loop bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loop = loop
+}
+
+//SetSpeed plays the recording speed times faster than it was recorded
+func (r *ReplayDevice) SetSpeed(speed float64) error {
+	if speed <= 0 {
+		return errors.New("speed must be positive")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.speed = speed
+	r.rate = r.recording.SamplesPerSecond * speed
+	r.restart()
+	return nil
+}
+
+//State returns where the replay is at
+func (r *ReplayDevice) State() ReplayState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return ReplayState{
+		Position: float64(r.pos) / r.recording.SamplesPerSecond,
+		Duration: r.recording.Duration().Seconds(),
+		Paused:   r.paused,
+		Loop:     r.loop,
+		Speed:    r.speed,
+	}
+}
@@ -0,0 +1,195 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/kevinjos/eeg-web-server/int24"
+)
+
+type testsignal struct {
+	label     string
+	dimension string
+	phymin    float64
+	phymax    float64
+	digmin    int32
+	digmax    int32
+	perRecord int
+	digital   []int32
+}
+
+//testRecording lays signals out as an EDF, or a BDF with bdf set
+func testRecording(bdf bool, duration float64, signals []testsignal) []byte {
+	ns := len(signals)
+	width := 2
+	version := "0"
+	if bdf {
+		width = 3
+		version = "\xffBIOSEMI"
+	}
+	records := len(signals[0].digital) / signals[0].perRecord
+	var buf bytes.Buffer
+	pad := func(s string, n int) {
+		fmt.Fprintf(&buf, "%-*s", n, s)
+	}
+	pad(version, 8)
+	pad("", 80)
+	pad("", 80)
+	pad("01.01.16", 8)
+	pad("00.00.00", 8)
+	pad(fmt.Sprint(256+256*ns), 8)
+	pad("", 44)
+	pad(fmt.Sprint(records), 8)
+	pad(fmt.Sprint(duration), 8)
+	pad(fmt.Sprint(ns), 4)
+	for _, field := range []struct {
+		n int
+		f func(s testsignal) string
+	}{
+		{16, func(s testsignal) string { return s.label }},
+		{80, func(s testsignal) string { return "" }},
+		{8, func(s testsignal) string { return s.dimension }},
+		{8, func(s testsignal) string { return fmt.Sprint(s.phymin) }},
+		{8, func(s testsignal) string { return fmt.Sprint(s.phymax) }},
+		{8, func(s testsignal) string { return fmt.Sprint(s.digmin) }},
+		{8, func(s testsignal) string { return fmt.Sprint(s.digmax) }},
+		{80, func(s testsignal) string { return "" }},
+		{8, func(s testsignal) string { return fmt.Sprint(s.perRecord) }},
+		{32, func(s testsignal) string { return "" }},
+	} {
+		for _, s := range signals {
+			pad(field.f(s), field.n)
+		}
+	}
+	for r := 0; r < records; r++ {
+		for _, s := range signals {
+			for _, d := range s.digital[r*s.perRecord : (r+1)*s.perRecord] {
+				if bdf {
+					buf.Write(int24.MarshalSLE(d))
+				} else {
+					buf.Write([]byte{byte(d), byte(d >> 8)})
+				}
+			}
+		}
+	}
+	if buf.Len() != 256+256*ns+records*width*len(signals)*signals[0].perRecord {
+		panic("bad test recording layout")
+	}
+	return buf.Bytes()
+}
+
+func TestReadRecording(t *testing.T) {
+	//a saveBDF style recording, one record holding everything
+	bdf := testRecording(true, 2, []testsignal{
+		{"", "uv", -187500, 187500, -8388608, 8388607, 4, []int32{0, 8388607, -8388608, 1000}},
+		{"", "uv", -187500, 187500, -8388608, 8388607, 4, []int32{-1, 2, -3, 4}},
+	})
+	rec, err := readRecording(bytes.NewReader(bdf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.SamplesPerSecond != 2 || len(rec.Signals) != 2 {
+		t.Fatal("For", "bdf", "expected", "2 signals at 2 Hz", "got", len(rec.Signals), rec.SamplesPerSecond)
+	}
+	for i, c := range []int32{0, 8388607, -8388608, 1000} {
+		expected := scaleToMicroVolts(c, 24)
+		if math.Abs(rec.Signals[0][i]-expected) > 0.05 {
+			t.Error("For", "sample", i, "expected", expected, "got", rec.Signals[0][i])
+		}
+	}
+
+	//an EDF in millivolts over several records with an annotation signal
+	edf := testRecording(false, 0.5, []testsignal{
+		{"Fp1", "mV", -1, 1, -32768, 32767, 2, []int32{-32768, 32767, 0, 0, 16384, -16384}},
+		{"EDF Annotations", "", -1, 1, -32768, 32767, 2, []int32{0, 0, 0, 0, 0, 0}},
+	})
+	rec, err = readRecording(bytes.NewReader(edf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.SamplesPerSecond != 4 || len(rec.Signals) != 1 || rec.Labels[0] != "Fp1" {
+		t.Fatal("For", "edf", "expected", "Fp1 alone at 4 Hz", "got", rec.Labels, rec.SamplesPerSecond)
+	}
+	for i, expected := range []float64{-1000, 1000, 0, 0, 500, -500} {
+		if math.Abs(rec.Signals[0][i]-expected) > 0.1 {
+			t.Error("For", "sample", i, "expected", expected, "got", rec.Signals[0][i])
+		}
+	}
+	if rec.Duration() != 1500*time.Millisecond {
+		t.Error("For", "duration", "expected", 1500*time.Millisecond, "got", rec.Duration())
+	}
+}
+
+func TestReplayDevice(t *testing.T) {
+	rec := &Recording{SamplesPerSecond: 125, Signals: make([][]float64, 10)}
+	for ch := range rec.Signals {
+		for i := 0; i < 5; i++ {
+			rec.Signals[ch] = append(rec.Signals[ch], float64(100*ch+i))
+		}
+	}
+	board, err := rec.Board()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !board.Daisy || board.SamplesPerSecond != 125 {
+		t.Fatal("For", "10 signals at 125 Hz", "expected", CytonDaisy, "got", board)
+	}
+	r := NewReplayDevice(rec, board, 1, true)
+	r.Write([]byte("x1000000X"))
+	r.Seek(32 * time.Millisecond)
+	var stream []byte
+	for i := 0; i < 5; i++ {
+		stream = append(stream, r.frames()...)
+	}
+	f := newFramer(bytes.NewReader(stream), readBufferSize)
+	gain := make([]float64, 16)
+	for i := range gain {
+		gain[i] = 24
+	}
+	gain[0] = 1
+	for i, pos := range []int{4, 0, 1, 2, 3} {
+		cyton, _, err := f.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := *cyton
+		daisy, _, err := f.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := encodePacket(&c, daisy, 100, gain, true)
+		for ch := 0; ch < 16; ch++ {
+			expected := 0.0
+			if ch < len(rec.Signals) {
+				expected = rec.Signals[ch][pos]
+			}
+			if math.Abs(p.Microvolts[ch]-expected) > scaleToMicroVolts(1, gain[ch]) {
+				t.Error("For", "packet", i, chanName(ch), "expected", expected, "got", p.Microvolts[ch])
+			}
+		}
+	}
+	if err := r.Seek(time.Second); err == nil {
+		t.Error("For", "seek past the end", "expected", "error", "got", err)
+	}
+}
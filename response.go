@@ -0,0 +1,54 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"time"
+)
+
+//responseEnd terminates every textual response of the firmware
+const responseEnd = "$$$"
+
+var errResponseTimeout = errors.New("timed out waiting for the board to respond")
+
+//readResponse reads the board's textual response up to and excluding
+//the $$$ terminator. The device read timeout shows up as io.EOF, reading
+//goes on until timeout has passed.
+func readResponse(device io.Reader, timeout time.Duration) (string, error) {
+	var resp bytes.Buffer
+	buf := make([]byte, 256)
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		n, err := device.Read(buf)
+		resp.Write(buf[:n])
+		if idx := bytes.Index(resp.Bytes(), []byte(responseEnd)); idx >= 0 {
+			return string(resp.Bytes()[:idx]), nil
+		}
+		if err != nil && err != io.EOF {
+			return resp.String(), err
+		}
+		if n == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	return resp.String(), errResponseTimeout
+}
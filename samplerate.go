@@ -0,0 +1,81 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+//sampleRateCommands maps the board sample rate in Hz to the argument of
+//the ~ command. Over the serial dongle only 250 Hz can be streamed, the
+//higher rates need the WiFi shield or go to the SD card.
+var sampleRateCommands = map[int]byte{
+	16000: '0',
+	8000:  '1',
+	4000:  '2',
+	2000:  '3',
+	1000:  '4',
+	500:   '5',
+	250:   '6',
+}
+
+var errSavingRate = errors.New("cannot change the sample rate while saving")
+
+//rawMsgSize keeps the raw messages at the same length in time as
+//RawMsgSize samples are at the default rate
+func rawMsgSize(rate int) int {
+	size := RawMsgSize * rate / samplesPerSecond
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
+
+//SetSampleRate switches the board to hz and has the decoder and the
+//filter and FFT stages rebuild themselves for the new rate. With the
+//daisy attached the effective rate is half of hz.
+func (mc *MindControl) SetSampleRate(hz int) error {
+	c, ok := sampleRateCommands[hz]
+	if !ok {
+		return fmt.Errorf("unsupported sample rate %d Hz", hz)
+	}
+	if mc.saving {
+		return errSavingRate
+	}
+	mc.mu.Lock()
+	firmware := mc.firmware
+	mc.mu.Unlock()
+	if firmware != nil && !firmware.SampleRate {
+		return fmt.Errorf("firmware %d has no sample rate command", firmware.Major)
+	}
+	_, err := mc.Execute(Command{Bytes: string([]byte{'~', c}), Pause: true})
+	if err != nil {
+		return err
+	}
+	mc.mu.Lock()
+	rate := hz
+	if mc.board.Daisy {
+		rate /= 2
+	}
+	mc.board.SamplesPerSecond = rate
+	board := mc.board
+	mc.mu.Unlock()
+	return mc.sendBoard(board)
+}
@@ -0,0 +1,76 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+type testratepair struct {
+	board   Board
+	hz      int
+	command string
+	rate    int
+}
+
+var testsrate = []testratepair{
+	{Cyton, 1000, "~4", 1000},
+	{Cyton, 16000, "~0", 16000},
+	{CytonDaisy, 500, "~5", 250},
+	{Cyton, 300, "", 0},
+}
+
+func TestSetSampleRate(t *testing.T) {
+	for _, pair := range testsrate {
+		device := &testDevice{r: bytes.NewReader(nil)}
+		mc := NewMindControl(nil, nil, device, nil, pair.board, RecoverRepeat)
+		go mc.runCommands()
+		rates := make(chan int, 2)
+		go func() {
+			resume := <-mc.pauseRead
+			<-resume
+			rates <- (<-mc.boardC).SamplesPerSecond
+			rates <- (<-mc.deltaBoard).SamplesPerSecond
+		}()
+		err := mc.SetSampleRate(pair.hz)
+		if pair.rate == 0 {
+			if err == nil {
+				t.Error("For", pair.hz, "expected an error")
+			}
+			continue
+		}
+		if err != nil || device.written.String() != pair.command ||
+			<-rates != pair.rate || <-rates != pair.rate || mc.board.SamplesPerSecond != pair.rate {
+			t.Error(
+				"For", pair.board.Name, pair.hz,
+				"expected", pair.command, pair.rate,
+				"got", err, device.written.String(), mc.board.SamplesPerSecond,
+			)
+		}
+	}
+}
+
+func TestRawMsgSize(t *testing.T) {
+	for rate, size := range map[int]int{250: 30, 125: 15, 16000: 1920, 1: 1} {
+		if res := rawMsgSize(rate); res != size {
+			t.Error("For", rate, "expected", size, "got", res)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//ImportResult tells where an SD card log went and how much of it was
+//made up to cover gaps
+type ImportResult struct {
+	File        string
+	Samples     int
+	Synthesized int
+}
+
+//parseSDLog reads an SD card log. Every line holds the sample index, one
+//24 bit count per channel and, on the samples that carry them, three 16
+//bit accelerometer values, all in hex. Lines starting with % are notes
+//of the firmware. Samples missing from the index are replaced according
+//to policy and every sample is stamped at rate from start.
+func parseSDLog(r io.Reader, board Board, gains []float64, policy RecoveryPolicy,
+	start time.Time) ([]*Packet, error) {
+	var packets []*Packet
+	//the log has one line per sample whether or not the daisy is attached
+	recoverer := newGapRecoverer(policy, Board{Channels: board.Channels})
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "%") {
+			continue
+		}
+		fields := strings.Split(text, ",")
+		if len(fields) != 1+board.Channels && len(fields) != 4+board.Channels {
+			return nil, fmt.Errorf("line %d: %d fields, expected %d or %d", line, len(fields),
+				1+board.Channels, 4+board.Channels)
+		}
+		seq, err := strconv.ParseUint(strings.TrimSpace(fields[0]), 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad sample index: %s", line, err)
+		}
+		p := NewPacket()
+		p.seqNum = byte(seq)
+		p.Sample = NewSample(board.Channels)
+		for ch := 0; ch < board.Channels; ch++ {
+			c, err := strconv.ParseUint(strings.TrimSpace(fields[1+ch]), 16, 24)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: bad count for %s: %s", line, chanName(ch), err)
+			}
+			p.Counts[ch] = int32(c<<8) >> 8
+			p.Microvolts[ch] = scaleToMicroVolts(p.Counts[ch], gains[ch])
+		}
+		if len(fields) == 4+board.Channels {
+			var acc [3]int16
+			for i := range acc {
+				a, err := strconv.ParseUint(strings.TrimSpace(fields[1+board.Channels+i]), 16, 16)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: bad aux value: %s", line, err)
+				}
+				acc[i] = int16(a)
+			}
+			p.AuxFormat = AuxAccel
+			p.AccX, p.AccY, p.AccZ = acc[0], acc[1], acc[2]
+		}
+		p.Synced = true
+		packets = append(packets, recoverer.recover(p)...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	period := time.Second / time.Duration(board.SamplesPerSecond)
+	for i, p := range packets {
+		p.Timestamp = start.Add(time.Duration(i) * period)
+		p.Received = p.Timestamp
+	}
+	return packets, nil
+}
+
+//ImportSDLog converts the SD card log on r into a recording at outfn, in
+//the layout saveBDF writes. The recording starts at start or, when start
+//is zero, is placed to end at end, e.g. the time the card last wrote to
+//the log.
+func ImportSDLog(r io.Reader, outfn string, board Board, gains []float64, policy RecoveryPolicy,
+	start, end time.Time) (ImportResult, error) {
+	if len(gains) != board.Channels {
+		return ImportResult{}, fmt.Errorf("%d gains for %d channels", len(gains), board.Channels)
+	}
+	packets, err := parseSDLog(r, board, gains, policy, start)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	span := time.Duration(len(packets)) * time.Second / time.Duration(board.SamplesPerSecond)
+	if start.IsZero() {
+		//the samples were stamped from the zero time, move them back
+		//from end by the length of the log
+		start = end.Add(-span)
+		for _, p := range packets {
+			p.Timestamp = start.Add(p.Timestamp.Sub(time.Time{}))
+			p.Received = p.Timestamp
+		}
+	}
+	w, err := newBDFWriter(filepath.Dir(outfn)+"/", gains)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer w.Close()
+	result := ImportResult{File: outfn, Samples: len(packets)}
+	for _, p := range packets {
+		w.write(p)
+		if p.Synthesized {
+			result.Synthesized++
+		}
+	}
+	return result, w.finish(outfn, start, start.Add(span))
+}
+
+//importBoard works out the board and gains an SD card log is read with.
+//The gains come from config, a board config as served by /config, and
+//default to gains when there is none.
+func importBoard(config []byte, daisy bool, rate int, gains []float64) (Board, []float64, error) {
+	board := Cyton
+	if daisy {
+		board = CytonDaisy
+	}
+	if rate <= 0 {
+		return board, nil, fmt.Errorf("bad sample rate %d", rate)
+	}
+	board.SamplesPerSecond = rate
+	if len(config) > 0 {
+		var c BoardConfig
+		err := json.Unmarshal(config, &c)
+		if err != nil {
+			return board, nil, fmt.Errorf("bad board config: %s", err)
+		}
+		gains = c.Gains()
+	}
+	if len(gains) != board.Channels {
+		return board, nil, fmt.Errorf("%d gains for %d channels", len(gains), board.Channels)
+	}
+	return board, gains, nil
+}
+
+//sdLogName is the recording an SD card log called name is imported as
+func sdLogName(dir, name string) string {
+	base := filepath.Base(name)
+	return filepath.Join(dir, strings.TrimSuffix(base, filepath.Ext(base))+".edf")
+}
+
+//importCommand is the import subcommand, it converts the SD card logs
+//named in args and returns the exit status
+func importCommand(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	configFile := fs.String("config", "", "board config JSON as served by /config, for the channel gains")
+	daisy := fs.Bool("daisy", false, "the log holds 16 channels")
+	rate := fs.Int("rate", samplesPerSecond, "sample rate the log was recorded at, per channel")
+	policyName := fs.String("recover", "repeat", "lost sample recovery: repeat, interpolate, zero, nan or drop")
+	out := fs.String("out", "data", "directory to write the recordings to")
+	startFlag := fs.String("start", "", "RFC 3339 time the recording started, by default it ends when the log was last written")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: eeg-server import [flags] file...")
+		fs.PrintDefaults()
+	}
+	if fs.Parse(args) != nil || fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	var config []byte
+	if *configFile != "" {
+		var err error
+		config, err = ioutil.ReadFile(*configFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	gains := Cyton.newGains()
+	if *daisy {
+		gains = CytonDaisy.newGains()
+	}
+	board, gains, err := importBoard(config, *daisy, *rate, gains)
+	if err == nil {
+		err = os.MkdirAll(*out, 0777)
+	}
+	policy, perr := ParseRecoveryPolicy(*policyName)
+	if err == nil {
+		err = perr
+	}
+	var start time.Time
+	if err == nil && *startFlag != "" {
+		start, err = time.Parse(time.RFC3339, *startFlag)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	status := 0
+	for _, name := range fs.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		//the card writes to the log until the recording stops
+		end := time.Now()
+		if info, err := f.Stat(); err == nil {
+			end = info.ModTime()
+		}
+		result, err := ImportSDLog(f, sdLogName(*out, name), board, gains, policy, start, end)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", name, err)
+			status = 1
+			continue
+		}
+		fmt.Printf("%s: %d samples, %d synthesized, written to %s\n", name, result.Samples,
+			result.Synthesized, result.File)
+	}
+	return status
+}
@@ -0,0 +1,123 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSDLog = `%OBCI SD Log - 2016-01-01
+0,000010,FFFFF0,000000,7FFFFF,800000,000001,FFFFFF,000002,0010,FFF0,2000
+1,000020,FFFFE0,000000,7FFFFF,800000,000001,FFFFFF,000002
+4,000050,FFFFB0,000000,7FFFFF,800000,000001,FFFFFF,000002
+
+%Total time mS:
+`
+
+func TestParseSDLog(t *testing.T) {
+	start := time.Unix(1451606400, 0)
+	packets, err := parseSDLog(strings.NewReader(testSDLog), Cyton, Cyton.newGains(), RecoverInterpolate, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 5 {
+		t.Fatal("For", "samples", "expected", 5, "got", len(packets))
+	}
+	expected := []int32{16, -16, 0, 8388607, -8388608, 1, -1, 2}
+	for ch, c := range expected {
+		if packets[0].Counts[ch] != c {
+			t.Error("For", chanName(ch), "expected", c, "got", packets[0].Counts[ch])
+		}
+		if packets[0].Microvolts[ch] != scaleToMicroVolts(c, 24) {
+			t.Error("For", chanName(ch), "expected", scaleToMicroVolts(c, 24), "got", packets[0].Microvolts[ch])
+		}
+	}
+	if packets[0].AuxFormat != AuxAccel || packets[0].AccX != 16 || packets[0].AccY != -16 || packets[0].AccZ != 8192 {
+		t.Error("For", "accelerometer", "expected", "16 -16 8192", "got", packets[0].AccX, packets[0].AccY, packets[0].AccZ)
+	}
+	//samples 2 and 3 are missing and interpolated
+	for i, c := range []int32{16, 32, 48, 64, 80} {
+		p := packets[i]
+		if p.Counts[0] != c || p.Synthesized != (i == 2 || i == 3) {
+			t.Error("For", "sample", i, "expected", c, "got", p.Counts[0], p.Synthesized)
+		}
+		if p.Timestamp != start.Add(time.Duration(i)*4*time.Millisecond) {
+			t.Error("For", "sample", i, "expected", start.Add(time.Duration(i)*4*time.Millisecond), "got", p.Timestamp)
+		}
+	}
+
+	for _, bad := range []string{"0,000010", "G,000010,0,0,0,0,0,0,0", "0,1000000,0,0,0,0,0,0,0"} {
+		_, err := parseSDLog(strings.NewReader(bad), Cyton, Cyton.newGains(), RecoverRepeat, start)
+		if err == nil {
+			t.Error("For", bad, "expected", "error", "got", err)
+		}
+	}
+}
+
+func TestImportBoard(t *testing.T) {
+	config := `{"Channels":[{"Gain":1},{"Gain":2},{"Gain":4},{"Gain":6},{"Gain":8},{"Gain":12},{"Gain":24},{"Gain":24}]}`
+	board, gains, err := importBoard([]byte(config), false, 500, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if board.SamplesPerSecond != 500 || gains[1] != 2 || gains[7] != 24 {
+		t.Error("For", config, "expected", "gains from the config at 500 Hz", "got", board, gains)
+	}
+	_, _, err = importBoard([]byte(config), true, 125, nil)
+	if err == nil {
+		t.Error("For", "8 gains on a daisy board", "expected", "error", "got", err)
+	}
+}
+
+func TestImportSDLogTimes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sdlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	at := time.Unix(1451606400, 0)
+	//five samples at 250 Hz take 20 ms
+	for _, anchor := range []struct {
+		start, end, first time.Time
+	}{
+		{at, time.Time{}, at},
+		{time.Time{}, at, at.Add(-20 * time.Millisecond)},
+	} {
+		outfn := dir + "/log.edf"
+		_, err := ImportSDLog(strings.NewReader(testSDLog), outfn, Cyton, Cyton.newGains(), RecoverRepeat,
+			anchor.start, anchor.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts, err := ioutil.ReadFile(dir + "/log.ts.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		first := strings.Split(strings.SplitN(string(ts), "\n", 2)[0], ",")[1]
+		if first != strconv.FormatInt(anchor.first.UnixNano(), 10) {
+			t.Error("For start", anchor.start, "and end", anchor.end, "expected the first sample at",
+				anchor.first.UnixNano(), "got", first)
+		}
+	}
+}
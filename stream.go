@@ -0,0 +1,128 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	//streamTick is how often a paced stream produces the frames that came due
+	streamTick = 4 * time.Millisecond
+	//streamReadTimeout stands in for the serial read timeout, an idle Read
+	//returns io.EOF after it
+	streamReadTimeout = 50 * time.Millisecond
+)
+
+//pacedStream is the reading half of the devices the server simulates. It
+//hands out frames in real time, as the serial port would, and text
+//responses ahead of them. Devices embed it and hold mu while they change
+//the pacing or their own state.
+type pacedStream struct {
+	mu        sync.Mutex
+	streaming bool
+	started   time.Time
+	emitted   int64
+	rate      float64
+	text      []byte
+	out       chan []byte
+	pending   []byte
+	quit      chan bool
+}
+
+func newPacedStream(rate float64, quit chan bool) pacedStream {
+	return pacedStream{
+		rate: rate,
+		out:  make(chan []byte, 256),
+		quit: quit,
+	}
+}
+
+//restart starts pacing over from now, mu must be held
+func (s *pacedStream) restart() {
+	s.started = time.Now()
+	s.emitted = 0
+}
+
+//run calls frames, with mu held, once for every sample that comes due
+//while streaming until quit is closed
+func (s *pacedStream) run(frames func() []byte) {
+	ticker := time.NewTicker(streamTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			var chunk []byte
+			if s.streaming {
+				due := int64(now.Sub(s.started).Seconds() * s.rate)
+				for ; s.emitted < due && s.streaming; s.emitted++ {
+					chunk = append(chunk, frames()...)
+				}
+			}
+			s.mu.Unlock()
+			if len(chunk) == 0 {
+				continue
+			}
+			select {
+			case s.out <- chunk:
+			case <-s.quit:
+				return
+			}
+		}
+	}
+}
+
+//Read returns pending text responses first and then the streamed frames
+func (s *pacedStream) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	if len(s.text) > 0 {
+		n := copy(p, s.text)
+		s.text = s.text[n:]
+		s.mu.Unlock()
+		return n, nil
+	}
+	s.mu.Unlock()
+	if len(s.pending) == 0 {
+		select {
+		case s.pending = <-s.out:
+		case <-time.After(streamReadTimeout):
+			return 0, io.EOF
+		case <-s.quit:
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	for n < len(p) {
+		select {
+		case s.pending = <-s.out:
+			m := copy(p[n:], s.pending)
+			s.pending = s.pending[m:]
+			n += m
+		default:
+			return n, nil
+		}
+	}
+	return n, nil
+}
@@ -1,20 +1,29 @@
 package main
 
-func calcFFTBins(fftSize int) (bins []float64) {
+import (
+	"io"
+	"os"
+)
+
+func calcFFTBins(fftSize int, sampleRate int) (bins []float64) {
 	bins = make([]float64, fftSize/2)
-	step := float64(samplesPerSecond) / float64(fftSize)
+	step := float64(sampleRate) / float64(fftSize)
 	for idx := range bins {
 		bins[idx] = step * float64(idx)
 	}
 	return bins
 }
 
-func normalizeInPlace(input []float64) {
-	var total float64
-	for _, val := range input {
-		total += val
+//copyFile writes everything in src, from the start, to a new file at dst
+func copyFile(dst string, src io.ReadSeeker) error {
+	if _, err := src.Seek(0, 0); err != nil {
+		return err
 	}
-	for idx := range input {
-		input[idx] /= total
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
 	}
+	defer out.Close()
+	_, err = io.Copy(out, src)
+	return err
 }
@@ -0,0 +1,257 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kevinjos/openbci-driver"
+)
+
+//shieldLatency is the microseconds the shield buffers before sending
+const shieldLatency = 10000
+
+var errShieldClosed = errors.New("wifi shield closed the connection")
+
+//WiFiDevice talks to a board through the OpenBCI WiFi shield. The shield
+//is told over its HTTP API to connect back to a TCP listener of ours and
+//stream the raw frames there; commands go out as HTTP calls and the
+//board's answers are read back in front of the frames.
+type WiFiDevice struct {
+	shield  string
+	client  *http.Client
+	ln      net.Listener
+	timeout time.Duration
+
+	mu      sync.Mutex
+	conn    net.Conn
+	text    []byte
+	cmd     []byte
+	closed  bool
+	arrived chan bool
+}
+
+//NewWiFiDevice connects to the shield at loc, tcp://host[:port] with
+//the port of the shield's HTTP API. Reads time out after timeout.
+func NewWiFiDevice(loc string, timeout time.Duration) (*WiFiDevice, error) {
+	u, err := url.Parse(loc)
+	if err != nil || u.Scheme != "tcp" || u.Host == "" {
+		return nil, fmt.Errorf("bad wifi shield location %q, expected tcp://host[:port]", loc)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "80")
+	}
+	//the address the shield reaches us at is the one we route to it from
+	probe, err := net.Dial("udp", host)
+	if err != nil {
+		return nil, err
+	}
+	local := probe.LocalAddr().(*net.UDPAddr).IP
+	probe.Close()
+	ln, err := net.Listen("tcp", net.JoinHostPort(local.String(), "0"))
+	if err != nil {
+		return nil, err
+	}
+	d := &WiFiDevice{
+		shield:  "http://" + host,
+		client:  &http.Client{Timeout: 5 * time.Second},
+		ln:      ln,
+		timeout: timeout,
+		arrived: make(chan bool, 1),
+	}
+	go d.accept()
+	_, err = d.call("POST", "/tcp", map[string]interface{}{
+		"ip":        local.String(),
+		"port":      ln.Addr().(*net.TCPAddr).Port,
+		"output":    "raw",
+		"delimiter": false,
+		"latency":   shieldLatency,
+	})
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+//accept takes the shield's connections, a new one replaces the last
+func (d *WiFiDevice) accept() {
+	for {
+		conn, err := d.ln.Accept()
+		if err != nil {
+			return
+		}
+		glog.Infof("wifi shield connected from %s\n", conn.RemoteAddr())
+		d.mu.Lock()
+		if d.conn != nil {
+			d.conn.Close()
+		}
+		d.conn = conn
+		d.mu.Unlock()
+		select {
+		case d.arrived <- true:
+		default:
+		}
+	}
+}
+
+//call makes a request to the shield's HTTP API and returns the body
+func (d *WiFiDevice) call(method, path string, body interface{}) ([]byte, error) {
+	var r io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequest(method, d.shield+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	out, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return out, fmt.Errorf("wifi shield %s %s: %s", method, path, resp.Status)
+	}
+	return out, nil
+}
+
+//Read returns the board's answers to commands first and then the frames
+//the shield streams. A quiet connection times out as io.EOF, the way the
+//serial device does; the shield hanging up is an error.
+func (d *WiFiDevice) Read(p []byte) (int, error) {
+	d.mu.Lock()
+	if len(d.text) > 0 {
+		n := copy(p, d.text)
+		d.text = d.text[n:]
+		d.mu.Unlock()
+		return n, nil
+	}
+	conn, closed := d.conn, d.closed
+	d.mu.Unlock()
+	if closed {
+		return 0, errShieldClosed
+	}
+	if conn == nil {
+		select {
+		case <-d.arrived:
+		case <-time.After(d.timeout):
+		}
+		return 0, io.EOF
+	}
+	conn.SetReadDeadline(time.Now().Add(d.timeout))
+	n, err := conn.Read(p)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return n, io.EOF
+	}
+	if err == io.EOF {
+		return n, errShieldClosed
+	}
+	return n, err
+}
+
+//Write sends commands through the shield. Start and stop map onto the
+//shield's stream calls, everything else is collected until the command
+//is complete and passed on to the board.
+func (d *WiFiDevice) Write(p []byte) (int, error) {
+	for i, b := range p {
+		d.mu.Lock()
+		d.cmd = append(d.cmd, b)
+		cmd := d.cmd
+		if len(cmd) < commandLength(cmd[0]) {
+			d.mu.Unlock()
+			continue
+		}
+		d.cmd = nil
+		d.mu.Unlock()
+		var (
+			resp []byte
+			err  error
+		)
+		switch {
+		case len(cmd) == 1 && cmd[0] == openbci.Command["start"]:
+			_, err = d.call("GET", "/stream/start", nil)
+		case len(cmd) == 1 && cmd[0] == openbci.Command["stop"]:
+			_, err = d.call("GET", "/stream/stop", nil)
+		default:
+			resp, err = d.call("POST", "/command", map[string]string{"command": string(cmd)})
+		}
+		if err != nil {
+			return i, err
+		}
+		d.mu.Lock()
+		d.text = append(d.text, resp...)
+		d.mu.Unlock()
+	}
+	return len(p), nil
+}
+
+//Close tells the shield to stop sending and drops the connection
+func (d *WiFiDevice) Close() error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	conn := d.conn
+	d.mu.Unlock()
+	_, err := d.call("DELETE", "/tcp", nil)
+	d.ln.Close()
+	if conn != nil {
+		conn.Close()
+	}
+	return err
+}
+
+//commandLength is the number of bytes in the command starting with b
+func commandLength(b byte) int {
+	switch b {
+	case 'x':
+		return 9
+	case 'z':
+		return 5
+	case '~':
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,127 @@
+/*  OpenBCI golang server allows users to control, visualize and store data
+    collected from the OpenBCI microcontroller.
+    Copyright (C) 2015  Kevin Schiesser
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+//testShield stands in for the WiFi shield's HTTP API and its stream
+type testShield struct {
+	mu       sync.Mutex
+	conn     net.Conn
+	commands []string
+	calls    []string
+}
+
+func (s *testShield) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, r.Method+" "+r.URL.Path)
+	switch r.Method + " " + r.URL.Path {
+	case "POST /tcp":
+		var cfg struct {
+			IP   string
+			Port int
+		}
+		json.NewDecoder(r.Body).Decode(&cfg)
+		conn, err := net.Dial("tcp", net.JoinHostPort(cfg.IP, strconv.Itoa(cfg.Port)))
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		s.conn = conn
+	case "GET /stream/start":
+		s.conn.Write(testStream(10))
+	case "POST /command":
+		var cmd struct{ Command string }
+		json.NewDecoder(r.Body).Decode(&cmd)
+		s.commands = append(s.commands, cmd.Command)
+		if cmd.Command == "?" {
+			fmt.Fprint(w, formatRegisters(newFirmware(Cyton).settings)+responseEnd)
+		}
+	case "DELETE /tcp", "GET /stream/stop":
+		s.conn.Close()
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestWiFiDevice(t *testing.T) {
+	shield := &testShield{}
+	server := httptest.NewServer(shield)
+	defer server.Close()
+	d, err := NewWiFiDevice("tcp://"+server.Listener.Addr().String(), 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	d.Write([]byte{'b'})
+	var stream []byte
+	p := make([]byte, 64)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(stream) < 10*frameSize && time.Now().Before(deadline) {
+		n, _ := d.Read(p)
+		stream = append(stream, p[:n]...)
+	}
+	if !bytes.Equal(stream, testStream(10)) {
+		t.Error("For", "stream", "expected", len(testStream(10)), "bytes", "got", len(stream))
+	}
+
+	//staggered the way writeCommand sends channel settings
+	for _, part := range []string{"x", "1060110", "X"} {
+		d.Write([]byte(part))
+	}
+	d.Write([]byte{'?'})
+	dump, err := readResponse(d, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := parseRegisters(dump)
+	if err != nil || len(config.Channels) != 8 {
+		t.Error("For", "register dump", "expected", 8, "channels", "got", config, err)
+	}
+	shield.mu.Lock()
+	commands := fmt.Sprint(shield.commands)
+	shield.mu.Unlock()
+	if commands != "[x1060110X ?]" {
+		t.Error("For", "commands", "expected", "[x1060110X ?]", "got", commands)
+	}
+
+	d.Write([]byte{'s'})
+	for time.Now().Before(deadline) {
+		_, err = d.Read(p)
+		if err == errShieldClosed {
+			break
+		}
+	}
+	if err != errShieldClosed {
+		t.Error("For", "shield hanging up", "expected", errShieldClosed, "got", err)
+	}
+}